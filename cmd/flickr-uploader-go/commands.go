@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/denisov/flickr-uploader-go/uploader"
+	"github.com/pkg/errors"
+)
+
+// runUpload синхронизирует локальные фото с Flickr: загружает новые, удаляет удалённые локально,
+// делит большие альбомы, заполняет умные альбомы, отправляет фото в группы, применяет настройки альбомов
+// и раскладывает альбомы по коллекциям
+func runUpload(uploaderService *uploader.Service) error {
+	err := uploaderService.InitPhotos()
+	if err != nil {
+		return err
+	}
+
+	uploaderService.SetFilesToProcess()
+
+	err = uploaderService.DetectMoves()
+	if err != nil {
+		return err
+	}
+
+	err = uploaderService.Upload()
+	if err != nil {
+		return err
+	}
+
+	err = uploaderService.CheckVideos()
+	if err != nil {
+		return err
+	}
+
+	err = uploaderService.Delete()
+	if err != nil {
+		return err
+	}
+
+	err = uploaderService.SplitAlbums()
+	if err != nil {
+		return err
+	}
+
+	err = uploaderService.SyncSmartAlbums()
+	if err != nil {
+		return err
+	}
+
+	err = uploaderService.SyncGroups()
+	if err != nil {
+		return err
+	}
+
+	err = uploaderService.SyncAlbums()
+	if err != nil {
+		return err
+	}
+
+	return uploaderService.SyncCollections()
+}
+
+// runOrphans ищет на Flickr фото загруженные программой, но отсутствующие в базе.
+// По умолчанию только выводит отчёт
+func runOrphans(uploaderService *uploader.Service, args []string) error {
+	flags := flag.NewFlagSet("orphans", flag.ExitOnError)
+	adopt := flags.Bool("adopt", false, "add orphans matched to local files into DB")
+	remove := flags.Bool("delete", false, "delete orphans without matching local file and duplicates from Flickr")
+	if err := flags.Parse(args); err != nil {
+		return errors.WithStack(err)
+	}
+
+	err := uploaderService.InitPhotos()
+	if err != nil {
+		return err
+	}
+
+	orphans, err := uploaderService.FindOrphans()
+	if err != nil {
+		return err
+	}
+
+	return uploaderService.ResolveOrphans(orphans, *adopt, *remove)
+}
+
+// runSetPrivacy применяет настройки видимости и местоположения из конфига к уже загруженным фото
+func runSetPrivacy(uploaderService *uploader.Service, args []string) error {
+	flags := flag.NewFlagSet("set-privacy", flag.ExitOnError)
+	force := flags.Bool("force", false, "apply to all photos, not only to photos which settings have changed")
+	if err := flags.Parse(args); err != nil {
+		return errors.WithStack(err)
+	}
+
+	err := uploaderService.InitPhotos()
+	if err != nil {
+		return err
+	}
+
+	return uploaderService.ApplyPrivacy(*force)
+}
+
+// runExplain выводит правила загрузки и настройки, с которыми загружается файл. Путь указывается
+// относительно текущей директории или директории с фото
+func runExplain(uploaderService *uploader.Service, args []string, photosPath string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	if err := flags.Parse(args); err != nil {
+		return errors.WithStack(err)
+	}
+	if flags.NArg() != 1 {
+		return errors.New("explain needs exactly one path")
+	}
+
+	path := flags.Arg(0)
+	if _, err := os.Stat(path); os.IsNotExist(err) && !filepath.IsAbs(path) {
+		path = filepath.Join(photosPath, path)
+	}
+	if filepath.IsAbs(photosPath) {
+		var err error
+		path, err = filepath.Abs(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	explanation, err := uploaderService.Explain(path)
+	if err != nil {
+		return err
+	}
+	fmt.Print(explanation)
+	return nil
+}
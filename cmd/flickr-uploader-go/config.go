@@ -2,12 +2,135 @@ package main
 
 import (
 	"io/ioutil"
+	"log"
 	"os"
+	"time"
 
+	flickruploader "github.com/denisov/flickr-uploader-go"
+	"github.com/denisov/flickr-uploader-go/uploader"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
+type tagsConfig struct {
+	Marker        string   `yaml:"marker"`
+	Static        []string `yaml:"static"`
+	DirComponents bool     `yaml:"dir_components"`
+	Templates     []string `yaml:"templates"`
+	Embedded      bool     `yaml:"embedded"`
+}
+
+type privacyConfig struct {
+	IsPublic    *bool `yaml:"is_public"`
+	IsFriend    *bool `yaml:"is_friend"`
+	IsFamily    *bool `yaml:"is_family"`
+	SafetyLevel *int  `yaml:"safety_level"`
+	ContentType *int  `yaml:"content_type"`
+	Hidden      *bool `yaml:"hidden"`
+}
+
+func (c privacyConfig) override() uploader.PrivacyOverride {
+	return uploader.PrivacyOverride{
+		IsPublic:    c.IsPublic,
+		IsFriend:    c.IsFriend,
+		IsFamily:    c.IsFamily,
+		SafetyLevel: c.SafetyLevel,
+		ContentType: c.ContentType,
+		Hidden:      c.Hidden,
+	}
+}
+
+type geoConfig struct {
+	Mode      *string `yaml:"mode"`
+	Accuracy  *int    `yaml:"accuracy"`
+	IsPublic  *bool   `yaml:"is_public"`
+	IsContact *bool   `yaml:"is_contact"`
+	IsFriend  *bool   `yaml:"is_friend"`
+	IsFamily  *bool   `yaml:"is_family"`
+}
+
+func (c geoConfig) override() uploader.GeoOverride {
+	override := uploader.GeoOverride{
+		Accuracy:  c.Accuracy,
+		IsPublic:  c.IsPublic,
+		IsContact: c.IsContact,
+		IsFriend:  c.IsFriend,
+		IsFamily:  c.IsFamily,
+	}
+	if c.Mode != nil {
+		mode := uploader.GeoMode(*c.Mode)
+		override.Mode = &mode
+	}
+	return override
+}
+
+type gpxConfig struct {
+	TracksDir string `yaml:"tracks_dir"`
+	PhotoDirs bool   `yaml:"photo_dirs"`
+	// CameraOffsetS устаревшая настройка, заменена dates.offset_s
+	CameraOffsetS int `yaml:"camera_offset_s"`
+	MaxGapS       int `yaml:"max_gap_s"`
+}
+
+type datesConfig struct {
+	Patterns       []string `yaml:"patterns"`
+	Mtime          bool     `yaml:"mtime"`
+	OffsetS        int      `yaml:"offset_s"`
+	BackdatePosted bool     `yaml:"backdate_posted"`
+}
+
+type photosetNamingConfig struct {
+	Strategy        string `yaml:"strategy"`
+	Separator       string `yaml:"separator"`
+	YearMonthLayout string `yaml:"year_month_layout"`
+	Template        string `yaml:"template"`
+	Root            string `yaml:"root"`
+	RootSet         string `yaml:"root_set"`
+}
+
+type albumsConfig struct {
+	Sort    string `yaml:"sort"`
+	Cover   string `yaml:"cover"`
+	MaxSize int    `yaml:"max_size"`
+	Split   string `yaml:"split"`
+}
+
+type collectionsConfig struct {
+	Levels int `yaml:"levels"`
+}
+
+type smartAlbumConfig struct {
+	Name      string   `yaml:"name"`
+	Year      int      `yaml:"year"`
+	Camera    string   `yaml:"camera"`
+	MinRating int      `yaml:"min_rating"`
+	Keywords  []string `yaml:"keywords"`
+	Dir       string   `yaml:"dir"`
+}
+
+type groupConfig struct {
+	Groups []string `yaml:"groups"`
+	Globs  []string `yaml:"globs"`
+	Tags   []string `yaml:"tags"`
+}
+
+type ruleConfig struct {
+	Name          string        `yaml:"name"`
+	Globs         []string      `yaml:"globs"`
+	Extensions    []string      `yaml:"extensions"`
+	Camera        string        `yaml:"camera"`
+	MinRating     int           `yaml:"min_rating"`
+	Year          int           `yaml:"year"`
+	HasGPS        *bool         `yaml:"has_gps"`
+	Keywords      []string      `yaml:"keywords"`
+	Skip          *bool         `yaml:"skip"`
+	Privacy       privacyConfig `yaml:"privacy"`
+	License       string        `yaml:"license"`
+	Tags          []string      `yaml:"tags"`
+	Album         *string       `yaml:"album"`
+	TitleTemplate string        `yaml:"title_template"`
+}
+
 type config struct {
 	TokenFileName     string   `yaml:"token_file_name"`
 	APIKey            string   `yaml:"api_key"`
@@ -16,6 +139,40 @@ type config struct {
 	PhotosPath        string   `yaml:"photos_path"`
 	ExcludeDirs       []string `yaml:"exclude_dirs"`
 	APIRequestSleepMs int      `yaml:"api_request_sleep_ms"`
+
+	Extensions        []string `yaml:"extensions"`
+	VideoMaxSizeMb    int64    `yaml:"video_max_size_mb"`
+	VideoMaxDurationS int      `yaml:"video_max_duration_s"`
+	RawExtensions     []string `yaml:"raw_extensions"`
+	RawPolicy         string   `yaml:"raw_policy"`
+	MinFileAgeS       int      `yaml:"min_file_age_s"`
+	StableCheckMs     int      `yaml:"stable_check_ms"`
+	ValidateImages    string   `yaml:"validate_images"`
+	MaxAttempts       int      `yaml:"max_attempts"`
+	RetryDelayMin     int      `yaml:"retry_delay_min"`
+
+	Tags                tagsConfig `yaml:"tags"`
+	TitleTemplate       string     `yaml:"title_template"`
+	DescriptionTemplate string     `yaml:"description_template"`
+	EmbeddedTitle       bool       `yaml:"embedded_title"`
+	EmbeddedDescription bool       `yaml:"embedded_description"`
+	MinRating           int        `yaml:"min_rating"`
+	SkipRejected        bool       `yaml:"skip_rejected"`
+
+	Privacy          privacyConfig            `yaml:"privacy"`
+	DirectoryPrivacy map[string]privacyConfig `yaml:"directory_privacy"`
+
+	Geo          geoConfig            `yaml:"geo"`
+	DirectoryGeo map[string]geoConfig `yaml:"directory_geo"`
+	GPX          gpxConfig            `yaml:"gpx"`
+
+	Dates          datesConfig          `yaml:"dates"`
+	PhotosetNaming photosetNamingConfig `yaml:"photoset_naming"`
+	Albums         albumsConfig         `yaml:"albums"`
+	Collections    collectionsConfig    `yaml:"collections"`
+	SmartAlbums    []smartAlbumConfig   `yaml:"smart_albums"`
+	Groups         []groupConfig        `yaml:"groups"`
+	Rules          []ruleConfig         `yaml:"rules"`
 }
 
 // todo возвращать не указатель
@@ -35,3 +192,94 @@ func newConfig(fileName string) (*config, error) {
 	}
 	return &newConfig, nil
 }
+
+// dateOffset возвращает поправку к дате съёмки из EXIF. Устаревшая gpx.camera_offset_s используется,
+// если dates.offset_s не задана: это одна и та же поправка часов камеры
+func (c *config) dateOffset() time.Duration {
+	if c.GPX.CameraOffsetS != 0 {
+		if c.Dates.OffsetS != 0 {
+			log.Printf("gpx.camera_offset_s is ignored, dates.offset_s is used")
+		} else {
+			log.Printf("gpx.camera_offset_s is deprecated, use dates.offset_s")
+			return time.Duration(c.GPX.CameraOffsetS) * time.Second
+		}
+	}
+	return time.Duration(c.Dates.OffsetS) * time.Second
+}
+
+// privacy возвращает видимость по умолчанию с учётом конфига
+func (c *config) privacy() flickruploader.Privacy {
+	return c.Privacy.override().Apply(flickruploader.DefaultPrivacy)
+}
+
+// directoryPrivacy возвращает переопределения видимости для директорий
+func (c *config) directoryPrivacy() []uploader.DirectoryPrivacy {
+	var dirs []uploader.DirectoryPrivacy
+	for dir, privacy := range c.DirectoryPrivacy {
+		dirs = append(dirs, uploader.DirectoryPrivacy{Dir: dir, Override: privacy.override()})
+	}
+	return dirs
+}
+
+// geo возвращает настройки местоположения из конфига
+func (c *config) geo() uploader.GeoOptions {
+	return c.Geo.override().Apply(uploader.GeoOptions{Mode: uploader.GeoKeep})
+}
+
+// directoryGeo возвращает переопределения местоположения для директорий
+func (c *config) directoryGeo() []uploader.DirectoryGeo {
+	var dirs []uploader.DirectoryGeo
+	for dir, geo := range c.DirectoryGeo {
+		dirs = append(dirs, uploader.DirectoryGeo{Dir: dir, Override: geo.override()})
+	}
+	return dirs
+}
+
+// smartAlbums возвращает правила умных альбомов в порядке конфига
+func (c *config) smartAlbums() []uploader.SmartAlbumRule {
+	var rules []uploader.SmartAlbumRule
+	for _, album := range c.SmartAlbums {
+		rules = append(rules, uploader.SmartAlbumRule{
+			Name:      album.Name,
+			Year:      album.Year,
+			Camera:    album.Camera,
+			MinRating: album.MinRating,
+			Keywords:  album.Keywords,
+			Dir:       album.Dir,
+		})
+	}
+	return rules
+}
+
+// groups возвращает правила отправки фото в группы
+func (c *config) groups() []uploader.GroupRule {
+	var rules []uploader.GroupRule
+	for _, group := range c.Groups {
+		rules = append(rules, uploader.GroupRule{Groups: group.Groups, Globs: group.Globs, Tags: group.Tags})
+	}
+	return rules
+}
+
+// rules возвращает правила загрузки в порядке конфига
+func (c *config) rules() []uploader.Rule {
+	var rules []uploader.Rule
+	for _, rule := range c.Rules {
+		rules = append(rules, uploader.Rule{
+			Name:          rule.Name,
+			Globs:         rule.Globs,
+			Extensions:    rule.Extensions,
+			Camera:        rule.Camera,
+			MinRating:     rule.MinRating,
+			Year:          rule.Year,
+			HasGPS:        rule.HasGPS,
+			Keywords:      rule.Keywords,
+			Skip:          rule.Skip,
+			Privacy:       rule.Privacy.override(),
+			License:       rule.License,
+			Tags:          rule.Tags,
+			Album:         rule.Album,
+			TitleTemplate: rule.TitleTemplate,
+		})
+	}
+	return rules
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/denisov/flickr-uploader-go/flickr"
+	"github.com/denisov/flickr-uploader-go/gpx"
+	"github.com/denisov/flickr-uploader-go/metadata"
 	"github.com/denisov/flickr-uploader-go/photofiles"
 	"github.com/denisov/flickr-uploader-go/sqlite"
 	"github.com/denisov/flickr-uploader-go/uploader"
@@ -19,6 +22,15 @@ func main() {
 	log.SetFlags(log.Lshortfile)
 
 	configFile := flag.String("config", "config.yml", "path to config.yml")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config config.yml] [command]\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  upload       sync photos to Flickr (default)")
+		fmt.Fprintln(flag.CommandLine.Output(), "  orphans      find tagged Flickr photos which are not in DB. Run 'orphans -h' for options")
+		fmt.Fprintln(flag.CommandLine.Output(), "  set-privacy  apply privacy settings from config to uploaded photos. Run 'set-privacy -h' for options")
+		fmt.Fprintln(flag.CommandLine.Output(), "  explain      show upload rules and settings which apply to a file: explain <path>")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	config, err := newConfig(*configFile)
@@ -31,50 +43,138 @@ func main() {
 		log.Fatalf("Can't create sqlite service: %+v", err)
 	}
 
-	photofilesService := photofiles.NewService(config.PhotosPath, config.ExcludeDirs)
+	photofilesService := photofiles.NewService(
+		config.PhotosPath,
+		config.ExcludeDirs,
+		photofiles.Options{
+			Extensions:       config.Extensions,
+			VideoMaxSize:     config.VideoMaxSizeMb * 1024 * 1024,
+			VideoMaxDuration: time.Duration(config.VideoMaxDurationS) * time.Second,
+			RawExtensions:    config.RawExtensions,
+			RawPolicy:        config.RawPolicy,
+			MinFileAge:       time.Duration(config.MinFileAgeS) * time.Second,
+			StableCheckDelay: time.Duration(config.StableCheckMs) * time.Millisecond,
+			Validate:         config.ValidateImages,
+		},
+	)
 
 	flickrService, err := flickr.NewService(
 		config.APIKey,
 		config.APISecret,
 		config.TokenFileName,
+		config.Tags.Marker,
 		time.Duration(config.APIRequestSleepMs)*time.Millisecond,
 	)
 	if err != nil {
 		log.Fatalf("Can't create flickr service %+v", err)
 	}
-	err = flickrService.SetToken()
-	if err != nil {
-		log.Fatalf("Can't set flickr token %+v", err)
-	}
 
-	uploader := uploader.NewService(
+	uploaderService, err := uploader.NewService(
 		photofilesService,
 		sqliteService,
 		flickrService,
+		metadata.NewService(sqliteService),
+		gpx.NewService(gpx.Options{
+			TracksDir: config.GPX.TracksDir,
+			PhotoDirs: config.GPX.PhotoDirs,
+			MaxGap:    time.Duration(config.GPX.MaxGapS) * time.Second,
+		}),
+		uploader.Options{
+			MaxAttempts: config.MaxAttempts,
+			RetryDelay:  time.Duration(config.RetryDelayMin) * time.Minute,
+			Tags: uploader.TagOptions{
+				Static:        config.Tags.Static,
+				DirComponents: config.Tags.DirComponents,
+				Templates:     config.Tags.Templates,
+				Embedded:      config.Tags.Embedded,
+			},
+			TitleTemplate:       config.TitleTemplate,
+			DescriptionTemplate: config.DescriptionTemplate,
+			EmbeddedTitle:       config.EmbeddedTitle,
+			EmbeddedDescription: config.EmbeddedDescription,
+			MinRating:           config.MinRating,
+			SkipRejected:        config.SkipRejected,
+			Privacy:             config.privacy(),
+			DirectoryPrivacy:    config.directoryPrivacy(),
+			Geo:                 config.geo(),
+			DirectoryGeo:        config.directoryGeo(),
+			Dates: uploader.DateOptions{
+				Patterns:       config.Dates.Patterns,
+				Mtime:          config.Dates.Mtime,
+				Offset:         config.dateOffset(),
+				BackdatePosted: config.Dates.BackdatePosted,
+			},
+			PhotosetNaming: uploader.NamingOptions{
+				Strategy:        config.PhotosetNaming.Strategy,
+				Separator:       config.PhotosetNaming.Separator,
+				YearMonthLayout: config.PhotosetNaming.YearMonthLayout,
+				Template:        config.PhotosetNaming.Template,
+				Root:            config.PhotosetNaming.Root,
+				RootSet:         config.PhotosetNaming.RootSet,
+			},
+			Albums: uploader.AlbumOptions{
+				Sort:    config.Albums.Sort,
+				Cover:   config.Albums.Cover,
+				MaxSize: config.Albums.MaxSize,
+				Split:   config.Albums.Split,
+			},
+			Collections: uploader.CollectionOptions{
+				Levels: config.Collections.Levels,
+			},
+			SmartAlbums: config.smartAlbums(),
+			Groups:      config.groups(),
+			Rules:       config.rules(),
+		},
 	)
-	err = uploader.InitPhotos()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Can't create uploader service %+v", err)
 	}
 
-	uploader.SetFilesToProcess()
+	// explain только читает файл и конфиг: токен Flickr не нужен, незавершённые загрузки не трогаются
+	if flag.Arg(0) == "explain" {
+		if err := runExplain(uploaderService, flag.Args()[1:], config.PhotosPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
 
-	stop := make(chan os.Signal)
+	err = flickrService.SetToken()
+	if err != nil {
+		log.Fatalf("Can't set flickr token %+v", err)
+	}
+
+	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		signal := <-stop
 		log.Printf("got signal: '%v'. Stopping ... ", signal)
-		uploader.Stop()
+		uploaderService.Stop()
 	}()
 
-	err = uploader.Upload()
+	// незавершённые загрузки доводятся до конца перед любой командой
+	err = uploaderService.Recover()
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
 
-	err = uploader.Delete()
+	switch command := flag.Arg(0); command {
+	case "", "upload":
+		err = runUpload(uploaderService)
+	case "orphans":
+		err = runOrphans(uploaderService, flag.Args()[1:])
+	case "set-privacy":
+		err = runSetPrivacy(uploaderService, flag.Args()[1:])
+	default:
+		log.Fatalf("Unknown command %q. Commands: upload (default), orphans, set-privacy, explain", command)
+	}
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
+
+	report := uploaderService.Report()
+	log.Printf("Done. %s", report)
+	if report.HasFailures() {
+		os.Exit(1)
+	}
 }
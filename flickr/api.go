@@ -0,0 +1,23 @@
+package flickr
+
+import (
+	"time"
+
+	"gopkg.in/masci/flickr.v2"
+)
+
+// call вызывает метод Flickr API, которого нет в библиотеке.
+// Повторяет то, что делают функции библиотеки: POST запрос подписанный OAuth токеном
+func (s *Service) call(method string, args map[string]string, response flickr.FlickrResponse) error {
+	time.Sleep(s.APIRequestSleep)
+
+	s.client.Init()
+	s.client.HTTPVerb = "POST"
+	s.client.Args.Set("method", method)
+	for name, value := range args {
+		s.client.Args.Set(name, value)
+	}
+	s.client.OAuthSign()
+
+	return flickr.DoPost(s.client, response)
+}
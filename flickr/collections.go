@@ -0,0 +1,84 @@
+package flickr
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2"
+)
+
+// Методы создания и изменения коллекций не описаны в документации Flickr API, поэтому перед использованием их
+// наличие проверяется, см. CollectionsSupported. В библиотеке их нет
+
+// methodNotFound код ошибки Flickr API для неизвестного метода
+const methodNotFound = 112
+
+// minCommonErrorCode коды ошибок Flickr API от этого и больше общие для всех методов: подпись, токен, права
+const minCommonErrorCode = 95
+
+// CollectionsSupported проверяет, что Flickr принимает методы изменения коллекций. Проверочный запрос editSets
+// к несуществующей коллекции ничего не меняет: если метод есть, Flickr отвечает ошибкой самого метода
+func (s *Service) CollectionsSupported() (bool, error) {
+	response := &flickr.BasicResponse{}
+	err := s.call("flickr.collections.editSets", map[string]string{
+		"collection_id": "0",
+		"photoset_ids":  "",
+	}, response)
+	switch {
+	case err == nil:
+		return true, nil
+	case response.ErrorCode() == methodNotFound:
+		return false, nil
+	case response.ErrorCode() > 0 && response.ErrorCode() < minCommonErrorCode:
+		return true, nil
+	}
+	return false, errors.Wrap(err, "Can't check collection methods")
+}
+
+type collectionResponse struct {
+	flickr.BasicResponse
+	Collection struct {
+		ID string `xml:"id,attr"`
+	} `xml:"collection"`
+}
+
+// CreateCollection создаёт коллекцию. Пустой parentID - коллекция верхнего уровня
+func (s *Service) CreateCollection(title, parentID string) (string, error) {
+	args := map[string]string{
+		"title":       title,
+		"description": "",
+	}
+	if parentID != "" {
+		args["parent_id"] = parentID
+	}
+
+	response := &collectionResponse{}
+	err := s.call("flickr.collections.create", args, response)
+	if err != nil {
+		return "", errors.Wrapf(err, "Can't create collection '%s'", title)
+	}
+	return response.Collection.ID, nil
+}
+
+// EditCollectionSets заменяет альбомы коллекции
+func (s *Service) EditCollectionSets(collectionID string, photosetIDs []string) error {
+	err := s.call("flickr.collections.editSets", map[string]string{
+		"collection_id": collectionID,
+		"photoset_ids":  strings.Join(photosetIDs, ","),
+	}, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "Can't edit photosets of collection %s", collectionID)
+	}
+	return nil
+}
+
+// DeleteCollection удаляет коллекцию. Альбомы коллекции остаются на Flickr
+func (s *Service) DeleteCollection(collectionID string) error {
+	err := s.call("flickr.collections.delete", map[string]string{
+		"collection_id": collectionID,
+	}, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "Can't delete collection %s", collectionID)
+	}
+	return nil
+}
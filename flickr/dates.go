@@ -0,0 +1,33 @@
+package flickr
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2/photos"
+)
+
+// dateTakenSetLayout формат даты съёмки для flickr.photos.setDates: время по часам места съёмки
+const dateTakenSetLayout = "2006-01-02 15:04:05"
+
+// SetDates устанавливает дату съёмки и дату публикации фото. Нулевое время не меняет соответствующую дату
+func (s *Service) SetDates(photoID string, taken, posted time.Time) error {
+	var dateTaken, datePosted string
+	if !taken.IsZero() {
+		dateTaken = taken.Format(dateTakenSetLayout)
+	}
+	if !posted.IsZero() {
+		datePosted = strconv.FormatInt(posted.Unix(), 10)
+	}
+	if dateTaken == "" && datePosted == "" {
+		return nil
+	}
+
+	time.Sleep(s.APIRequestSleep)
+	_, err := photos.SetDates(s.client, photoID, datePosted, dateTaken)
+	if err != nil {
+		return errors.Wrapf(err, "can't set dates of photo %s", photoID)
+	}
+	return nil
+}
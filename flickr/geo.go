@@ -0,0 +1,50 @@
+package flickr
+
+import (
+	"strconv"
+
+	flickruploader "github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2"
+)
+
+// SetGeo устанавливает местоположение фото и права на его просмотр или удаляет местоположение
+func (s *Service) SetGeo(photoID string, geo flickruploader.Geo) error {
+	if geo.IsZero() {
+		return nil
+	}
+	if geo.Remove {
+		err := s.call("flickr.photos.geo.removeLocation", map[string]string{
+			"photo_id": photoID,
+		}, &flickr.BasicResponse{})
+		if err != nil {
+			return errors.Wrapf(err, "can't remove location of photo %s", photoID)
+		}
+		return nil
+	}
+
+	args := map[string]string{
+		"photo_id": photoID,
+		"lat":      strconv.FormatFloat(geo.Latitude, 'f', 6, 64),
+		"lon":      strconv.FormatFloat(geo.Longitude, 'f', 6, 64),
+	}
+	if geo.Accuracy >= 1 && geo.Accuracy <= 16 {
+		args["accuracy"] = strconv.Itoa(geo.Accuracy)
+	}
+	err := s.call("flickr.photos.geo.setLocation", args, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "can't set location of photo %s", photoID)
+	}
+
+	err = s.call("flickr.photos.geo.setPerms", map[string]string{
+		"photo_id":   photoID,
+		"is_public":  boolArg(geo.Perms.IsPublic),
+		"is_contact": boolArg(geo.Perms.IsContact),
+		"is_friend":  boolArg(geo.Perms.IsFriend),
+		"is_family":  boolArg(geo.Perms.IsFamily),
+	}, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "can't set geo perms of photo %s", photoID)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package flickr
+
+import (
+	"strconv"
+
+	flickruploader "github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2"
+)
+
+// Коды ошибок flickr.groups.pools.add
+const (
+	groupErrNotFound      = 1
+	groupErrPhotoNotFound = 2
+	groupErrInPool        = 3
+	groupErrMaxPools      = 4
+	groupErrThrottled     = 5
+	groupErrModeration    = 6
+	groupErrInModeration  = 7
+	groupErrNotAllowed    = 8
+	groupErrPoolFull      = 10
+)
+
+type groupInfoResponse struct {
+	flickr.BasicResponse
+	Group struct {
+		Throttle struct {
+			Mode      string `xml:"mode,attr"`
+			Remaining string `xml:"remaining,attr"`
+		} `xml:"throttle"`
+	} `xml:"group"`
+}
+
+// GroupRemaining возвращает сколько фото ещё можно добавить в пул группы до конца периода ограничения,
+// -1 если группа не ограничивает добавление
+func (s *Service) GroupRemaining(groupID string) (int, error) {
+	response := &groupInfoResponse{}
+	err := s.call("flickr.groups.getInfo", map[string]string{"group_id": groupID}, response)
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get info of group %s", groupID)
+	}
+
+	throttle := response.Group.Throttle
+	switch {
+	case throttle.Mode == "disabled":
+		return 0, nil
+	case throttle.Mode == "" || throttle.Mode == "none" || throttle.Remaining == "":
+		return -1, nil
+	}
+	remaining, err := strconv.Atoi(throttle.Remaining)
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't parse throttle of group %s", groupID)
+	}
+	return remaining, nil
+}
+
+// AddPhotoToGroup добавляет фото в пул группы и возвращает состояние фото в пуле.
+// GroupQueued без ошибки - группа ограничила добавление или пул заполнен, фото нужно отправить позже.
+// GroupQueued с ошибкой - фото уже в максимальном числе пулов, его можно отправить позже, остальные фото - сейчас.
+// GroupRejected возвращается вместе с причиной отказа, пустое состояние - временная ошибка
+func (s *Service) AddPhotoToGroup(photoID, groupID string) (flickruploader.GroupState, error) {
+	response := &flickr.BasicResponse{}
+	err := s.call("flickr.groups.pools.add", map[string]string{
+		"photo_id": photoID,
+		"group_id": groupID,
+	}, response)
+	if err == nil {
+		return flickruploader.GroupAdded, nil
+	}
+
+	switch response.ErrorCode() {
+	case groupErrInPool:
+		return flickruploader.GroupAdded, nil
+	case groupErrModeration, groupErrInModeration:
+		return flickruploader.GroupModeration, nil
+	case groupErrThrottled, groupErrPoolFull:
+		return flickruploader.GroupQueued, nil
+	case groupErrMaxPools:
+		return flickruploader.GroupQueued, errors.Wrapf(err, "photo %s is in the maximum number of pools", photoID)
+	case groupErrNotFound, groupErrPhotoNotFound, groupErrNotAllowed:
+		return flickruploader.GroupRejected, errors.Wrapf(err, "group %s rejected photo %s", groupID, photoID)
+	}
+	return "", errors.Wrapf(err, "can't add photo %s to group %s", photoID, groupID)
+}
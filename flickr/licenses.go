@@ -0,0 +1,18 @@
+package flickr
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2"
+)
+
+// SetLicense устанавливает лицензию фото. licenseID - ID лицензии из flickr.photos.licenses.getInfo
+func (s *Service) SetLicense(photoID, licenseID string) error {
+	err := s.call("flickr.photos.licenses.setLicense", map[string]string{
+		"photo_id":   photoID,
+		"license_id": licenseID,
+	}, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "Can't set license %s of photo %s", licenseID, photoID)
+	}
+	return nil
+}
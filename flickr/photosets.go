@@ -0,0 +1,117 @@
+package flickr
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2"
+	"gopkg.in/masci/flickr.v2/photosets"
+)
+
+type photosetPhotosResponse struct {
+	flickr.BasicResponse
+	Photoset struct {
+		Primary string `xml:"primary,attr"`
+		Page    int    `xml:"page,attr"`
+		Pages   int    `xml:"pages,attr"`
+		Total   int    `xml:"total,attr"`
+		Photos  []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"photo"`
+	} `xml:"photoset"`
+}
+
+// getPhotosetPage возвращает страницу фото альбома
+func (s *Service) getPhotosetPage(photosetID string, page int) (*photosetPhotosResponse, error) {
+	response := &photosetPhotosResponse{}
+	err := s.call("flickr.photosets.getPhotos", map[string]string{
+		"photoset_id": photosetID,
+		"user_id":     "me",
+		"per_page":    strconv.Itoa(searchPerPage),
+		"page":        strconv.Itoa(page),
+	}, response)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get photos of photoset %s, page %d", photosetID, page)
+	}
+	return response, nil
+}
+
+// AddPhotosToPhotoset добавляет фото в альбом. Много фото добавляются одним запросом flickr.photosets.editPhotos,
+// который заменяет весь состав альбома, поэтому текущий состав сначала читается с Flickr по страницам.
+// Массовое добавление используется, только если оно дешевле добавления по одному: страниц состава + 1 < количества фото
+func (s *Service) AddPhotosToPhotoset(photosetID string, photoIDs []string) error {
+	// у альбома минимум одна страница, двум фото массовое добавление не выгодно
+	if len(photoIDs) <= 2 {
+		return s.addPhotosOneByOne(photosetID, photoIDs)
+	}
+
+	first, err := s.getPhotosetPage(photosetID, 1)
+	if err != nil {
+		return err
+	}
+	if first.Photoset.Pages+1 >= len(photoIDs) {
+		return s.addPhotosOneByOne(photosetID, photoIDs)
+	}
+
+	primaryID := first.Photoset.Primary
+	var current []string
+	for page, response := 1, first; ; page++ {
+		if page > 1 {
+			response, err = s.getPhotosetPage(photosetID, page)
+			if err != nil {
+				return err
+			}
+		}
+		for _, photo := range response.Photoset.Photos {
+			current = append(current, photo.ID)
+		}
+		if page >= response.Photoset.Pages {
+			break
+		}
+	}
+	// неполный состав заменил бы альбом без части фото
+	if len(current) != first.Photoset.Total {
+		return errors.Errorf("read %d of %d photos of photoset %s, membership changed during reading",
+			len(current), first.Photoset.Total, photosetID)
+	}
+
+	seen := map[string]bool{}
+	var all []string
+	for _, photoID := range append(current, photoIDs...) {
+		if !seen[photoID] {
+			seen[photoID] = true
+			all = append(all, photoID)
+		}
+	}
+	if primaryID == "" {
+		primaryID = all[0]
+	}
+
+	time.Sleep(s.APIRequestSleep)
+	_, err = photosets.EditPhotos(s.client, photosetID, primaryID, all)
+	if err != nil {
+		return errors.Wrapf(err, "Can't add %d photos to photoset %s", len(photoIDs), photosetID)
+	}
+	return nil
+}
+
+// addPhotosOneByOne добавляет фото в альбом по одному
+func (s *Service) addPhotosOneByOne(photosetID string, photoIDs []string) error {
+	for _, photoID := range photoIDs {
+		if err := s.AddPhotoToPhotoset(photoID, photosetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemovePhotosFromPhotoset убирает фото из альбома, сами фото остаются на Flickr
+func (s *Service) RemovePhotosFromPhotoset(photosetID string, photoIDs []string) error {
+	time.Sleep(s.APIRequestSleep)
+	_, err := photosets.RemovePhotos(s.client, photosetID, photoIDs)
+	if err != nil {
+		return errors.Wrapf(err, "Can't remove %d photos from photoset %s", len(photoIDs), photosetID)
+	}
+	return nil
+}
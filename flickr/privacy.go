@@ -0,0 +1,54 @@
+package flickr
+
+import (
+	"strconv"
+
+	flickruploader "github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2"
+)
+
+// boolArg форматирует флаг для Flickr API
+func boolArg(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// SetPrivacy меняет видимость, уровень безопасности и тип содержимого уже загруженного фото
+func (s *Service) SetPrivacy(photoID string, privacy flickruploader.Privacy) error {
+	err := s.call("flickr.photos.setPerms", map[string]string{
+		"photo_id":  photoID,
+		"is_public": boolArg(privacy.IsPublic),
+		"is_friend": boolArg(privacy.IsFriend),
+		"is_family": boolArg(privacy.IsFamily),
+	}, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "can't set perms of photo %s", photoID)
+	}
+
+	args := map[string]string{
+		"photo_id": photoID,
+		// в отличие от загрузки здесь 1 - скрыть из поиска, 0 - показывать
+		"hidden": boolArg(privacy.Hidden),
+	}
+	if privacy.SafetyLevel >= 1 && privacy.SafetyLevel <= 3 {
+		args["safety_level"] = strconv.Itoa(privacy.SafetyLevel)
+	}
+	err = s.call("flickr.photos.setSafetyLevel", args, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "can't set safety level of photo %s", photoID)
+	}
+
+	if privacy.ContentType >= 1 && privacy.ContentType <= 3 {
+		err = s.call("flickr.photos.setContentType", map[string]string{
+			"photo_id":     photoID,
+			"content_type": strconv.Itoa(privacy.ContentType),
+		}, &flickr.BasicResponse{})
+		if err != nil {
+			return errors.Wrapf(err, "can't set content type of photo %s", photoID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package flickr
+
+import (
+	"strconv"
+	"time"
+
+	flickruploader "github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+	"gopkg.in/masci/flickr.v2"
+	"gopkg.in/masci/flickr.v2/photosets"
+)
+
+// searchPerPage максимальный размер страницы flickr.photos.search
+const searchPerPage = 500
+
+// dateTakenLayout формат даты съёмки в Flickr API
+const dateTakenLayout = "2006-01-02 15:04:05"
+
+// SearchPhoto это фото из результатов поиска
+type SearchPhoto struct {
+	ID         string `xml:"id,attr"`
+	Title      string `xml:"title,attr"`
+	DateUpload string `xml:"dateupload,attr"`
+	DateTaken  string `xml:"datetaken,attr"`
+}
+
+type searchResponse struct {
+	flickr.BasicResponse
+	Photos struct {
+		Page   int           `xml:"page,attr"`
+		Pages  int           `xml:"pages,attr"`
+		Photos []SearchPhoto `xml:"photo"`
+	} `xml:"photos"`
+}
+
+// searchPhotos ищет фото пользователя, загруженные этой программой, по всем страницам результатов
+func (s *Service) searchPhotos(args map[string]string) ([]SearchPhoto, error) {
+	var result []SearchPhoto
+	for page := 1; ; page++ {
+		pageArgs := map[string]string{
+			"user_id":  "me",
+			"tags":     s.markerTag + "," + legacyMarkerTag,
+			"tag_mode": "any",
+			"extras":   "date_upload,date_taken",
+			"per_page": strconv.Itoa(searchPerPage),
+			"page":     strconv.Itoa(page),
+		}
+		for name, value := range args {
+			pageArgs[name] = value
+		}
+
+		response := &searchResponse{}
+		err := s.call("flickr.photos.search", pageArgs, response)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't search photos, page %d", page)
+		}
+		result = append(result, response.Photos.Photos...)
+
+		if page >= response.Photos.Pages {
+			return result, nil
+		}
+	}
+}
+
+// FindUploadedPhotos ищет фото с названием title, загруженные не раньше since. Возвращает ID всех найденных фото
+func (s *Service) FindUploadedPhotos(title string, since time.Time) ([]string, error) {
+	found, err := s.searchPhotos(map[string]string{
+		// время на сервере flickr может немного отличаться
+		"min_upload_date": strconv.FormatInt(since.Add(-time.Hour).Unix(), 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var photoIDs []string
+	for _, photo := range found {
+		if photo.Title == title {
+			photoIDs = append(photoIDs, photo.ID)
+		}
+	}
+	return photoIDs, nil
+}
+
+// FindPhotoset ищет фотосет по названию. Возвращает ID фотосета или пустую строку, если не найден
+func (s *Service) FindPhotoset(title string) (string, error) {
+	for page := 1; ; page++ {
+		time.Sleep(s.APIRequestSleep)
+		response, err := photosets.GetList(s.client, true, "", page)
+		if err != nil {
+			return "", errors.Wrapf(err, "can't get photosets, page %d", page)
+		}
+		for _, set := range response.Photosets.Items {
+			if set.Title == title {
+				return set.Id, nil
+			}
+		}
+		if page >= response.Photosets.Pages {
+			return "", nil
+		}
+	}
+}
+
+// ListUploadedPhotos возвращает все фото пользователя, помеченные тегом программы
+func (s *Service) ListUploadedPhotos() ([]flickruploader.RemotePhoto, error) {
+	found, err := s.searchPhotos(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]flickruploader.RemotePhoto, 0, len(found))
+	for _, photo := range found {
+		remotePhoto := flickruploader.RemotePhoto{
+			ID:    photo.ID,
+			Title: photo.Title,
+		}
+		if uploaded, err := strconv.ParseInt(photo.DateUpload, 10, 64); err == nil {
+			remotePhoto.DateUploaded = time.Unix(uploaded, 0)
+		}
+		if taken, err := time.ParseInLocation(dateTakenLayout, photo.DateTaken, time.Local); err == nil {
+			remotePhoto.DateTaken = taken
+		}
+		result = append(result, remotePhoto)
+	}
+	return result, nil
+}
@@ -2,29 +2,43 @@ package flickr
 
 import (
 	"log"
+	"strings"
 	"time"
 
+	flickruploader "github.com/denisov/flickr-uploader-go"
 	"github.com/pkg/errors"
 	"gopkg.in/masci/flickr.v2"
 	"gopkg.in/masci/flickr.v2/photos"
 	"gopkg.in/masci/flickr.v2/photosets"
 )
 
+// DefaultMarkerTag машинный тег, которым по умолчанию помечаются все загруженные фото
+const DefaultMarkerTag = "fug:managed=1"
+
+// legacyMarkerTag тег, которым помечали фото старые версии. Учитывается при поиске загруженных фото
+const legacyMarkerTag = "flickruploadergo"
+
 // Service это сервис для работы с Flickr
 type Service struct {
 	client          *flickr.FlickrClient
 	tokenFile       string
+	markerTag       string
 	APIRequestSleep time.Duration
 }
 
 // NewService создаёт новый сервис для для работы с flickr
-func NewService(APIKey, APISecret, tokenFile string, APIRequestSleep time.Duration) (*Service, error) {
+// markerTag тег программы, пустая строка - DefaultMarkerTag
+func NewService(APIKey, APISecret, tokenFile, markerTag string, APIRequestSleep time.Duration) (*Service, error) {
 
 	client := flickr.NewFlickrClient(APIKey, APISecret)
+	if markerTag == "" {
+		markerTag = DefaultMarkerTag
+	}
 
 	return &Service{
 		client:          client,
 		tokenFile:       tokenFile,
+		markerTag:       markerTag,
 		APIRequestSleep: APIRequestSleep,
 	}, nil
 }
@@ -58,11 +72,26 @@ func (s *Service) SetToken() error {
 
 }
 
-// UploadPhoto загружает фото на flickr
-func (s *Service) UploadPhoto(photoPath string) (string, error) {
+// UploadPhoto загружает фото на flickr с метаданными meta. К тегам добавляется тег программы
+func (s *Service) UploadPhoto(photoPath string, meta flickruploader.UploadMeta) (string, error) {
 	time.Sleep(s.APIRequestSleep)
 	params := flickr.NewUploadParams()
-	params.Tags = []string{"flickruploadergo"}
+	params.Title = meta.Title
+	params.Description = meta.Description
+	params.IsPublic = meta.Privacy.IsPublic
+	params.IsFriend = meta.Privacy.IsFriend
+	params.IsFamily = meta.Privacy.IsFamily
+	params.SafetyLevel = meta.Privacy.SafetyLevel
+	params.ContentType = meta.Privacy.ContentType
+	// 1 - показывать в публичном поиске, 2 - скрыть
+	params.Hidden = 1
+	if meta.Privacy.Hidden {
+		params.Hidden = 2
+	}
+	params.Tags = []string{s.markerTag}
+	for _, tag := range meta.Tags {
+		params.Tags = append(params.Tags, quoteTag(tag))
+	}
 
 	response, err := flickr.UploadFile(s.client, photoPath, params)
 	// иногода flickr 500-тит.
@@ -87,6 +116,15 @@ func (s *Service) UploadPhoto(photoPath string) (string, error) {
 	return response.ID, nil
 }
 
+// quoteTag заключает в кавычки тег с пробелами, иначе flickr разобьёт его на несколько
+func quoteTag(tag string) string {
+	tag = strings.Replace(tag, `"`, "", -1)
+	if strings.ContainsAny(tag, " \t") {
+		return `"` + tag + `"`
+	}
+	return tag
+}
+
 // DeletePhoto удаляет фото на flickr
 func (s *Service) DeletePhoto(photoID string) error {
 	time.Sleep(s.APIRequestSleep)
@@ -134,3 +172,78 @@ func (s *Service) AddPhotoToPhotoset(photoID, photosetID string) error {
 	}
 	return nil
 }
+
+type videoInfoResponse struct {
+	flickr.BasicResponse
+	Photo struct {
+		Media string `xml:"media,attr"`
+		Video struct {
+			Ready   int `xml:"ready,attr"`
+			Failed  int `xml:"failed,attr"`
+			Pending int `xml:"pending,attr"`
+		} `xml:"video"`
+	} `xml:"photo"`
+}
+
+// RenamePhotoset меняет название альбома
+func (s *Service) RenamePhotoset(photosetID, title string) error {
+	time.Sleep(s.APIRequestSleep)
+	_, err := photosets.EditMeta(s.client, photosetID, title, "")
+	if err != nil {
+		return errors.Wrapf(err, "Can't rename photoset %s to '%s'", photosetID, title)
+	}
+	return nil
+}
+
+// EditPhotoset меняет название и описание альбома.
+// Библиотека не передаёт пустое описание, а его нужно уметь очищать
+func (s *Service) EditPhotoset(photosetID, title, description string) error {
+	err := s.call("flickr.photosets.editMeta", map[string]string{
+		"photoset_id": photosetID,
+		"title":       title,
+		"description": description,
+	}, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "Can't edit photoset %s", photosetID)
+	}
+	return nil
+}
+
+// SetPhotosetCover меняет обложку альбома
+func (s *Service) SetPhotosetCover(photosetID, photoID string) error {
+	time.Sleep(s.APIRequestSleep)
+	_, err := photosets.SetPrimaryPhoto(s.client, photosetID, photoID)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set cover of photoset %s to %s", photosetID, photoID)
+	}
+	return nil
+}
+
+// ReorderPhotoset упорядочивает фото в альбоме. Фото, которых нет в photoIDs, остаются после них
+func (s *Service) ReorderPhotoset(photosetID string, photoIDs []string) error {
+	err := s.call("flickr.photosets.reorderPhotos", map[string]string{
+		"photoset_id": photosetID,
+		"photo_ids":   strings.Join(photoIDs, ","),
+	}, &flickr.BasicResponse{})
+	if err != nil {
+		return errors.Wrapf(err, "Can't reorder photoset %s", photosetID)
+	}
+	return nil
+}
+
+// GetVideoStatus возвращает статус обработки загруженного видео
+func (s *Service) GetVideoStatus(photoID string) (flickruploader.VideoStatus, error) {
+	response := &videoInfoResponse{}
+	err := s.call("flickr.photos.getInfo", map[string]string{"photo_id": photoID}, response)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't get info of video %s", photoID)
+	}
+
+	switch {
+	case response.Photo.Video.Failed != 0:
+		return flickruploader.VideoFailed, nil
+	case response.Photo.Video.Ready != 0:
+		return flickruploader.VideoReady, nil
+	}
+	return flickruploader.VideoPending, nil
+}
@@ -0,0 +1,111 @@
+package gpx
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+)
+
+// DefaultMaxGap максимальное расстояние по времени до точки трека, если в настройках ничего не задано
+const DefaultMaxGap = 5 * time.Minute
+
+// Options это настройки геотеггинга по трекам
+type Options struct {
+	// TracksDir директория с GPX файлами (ищутся рекурсивно), пустая строка - не используется
+	TracksDir string
+	// PhotoDirs искать GPX файлы в директории фото
+	PhotoDirs bool
+	// MaxGap максимальное расстояние по времени до записанной точки трека
+	MaxGap time.Duration
+}
+
+// Service находит координаты фото по GPX трекам. Файлы фото не изменяются
+type Service struct {
+	options Options
+
+	// tracks сегменты из TracksDir, nil пока не прочитаны
+	tracks []segment
+	// dirTracks сегменты из директорий фото, ключ - директория
+	dirTracks map[string][]segment
+}
+
+// NewService создаёт сервис
+func NewService(options Options) *Service {
+	if options.MaxGap <= 0 {
+		options.MaxGap = DefaultMaxGap
+	}
+	return &Service{
+		options:   options,
+		dirTracks: map[string][]segment{},
+	}
+}
+
+// Locate возвращает положение в момент съёмки фото path. ok false если подходящей точки нет
+func (s *Service) Locate(path string, taken time.Time) (point flickruploader.TrackPoint, ok bool) {
+	if taken.IsZero() {
+		return flickruploader.TrackPoint{}, false
+	}
+	var best time.Duration
+	for _, track := range s.segments(filepath.Dir(path)) {
+		candidate, distance, found := track.locate(taken, s.options.MaxGap)
+		if found && (!ok || distance < best) {
+			point, best, ok = candidate, distance, true
+		}
+	}
+	return point, ok
+}
+
+// segments возвращает сегменты треков, подходящие для фото из директории dir
+func (s *Service) segments(dir string) []segment {
+	if s.options.TracksDir != "" && s.tracks == nil {
+		s.tracks = readTracks(s.options.TracksDir, true)
+	}
+	if !s.options.PhotoDirs {
+		return s.tracks
+	}
+
+	dirTracks, ok := s.dirTracks[dir]
+	if !ok {
+		dirTracks = readTracks(dir, false)
+		s.dirTracks[dir] = dirTracks
+	}
+	segments := make([]segment, 0, len(dirTracks)+len(s.tracks))
+	segments = append(segments, dirTracks...)
+	return append(segments, s.tracks...)
+}
+
+// readTracks читает все GPX файлы директории. Ошибки только логируются: без трека фото загружается без координат
+func readTracks(dir string, recursive bool) []segment {
+	segments := []segment{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.ToLower(filepath.Ext(path)) != ".gpx" {
+			return nil
+		}
+
+		track, err := readTrack(path)
+		if err != nil {
+			log.Printf("Can't read track: %s", err)
+			return nil
+		}
+		log.Printf("Track %q: %d segments", path, len(track))
+		segments = append(segments, track...)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Can't read tracks from %q: %s", dir, err)
+	}
+	return segments
+}
@@ -0,0 +1,119 @@
+package gpx
+
+import (
+	"encoding/xml"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// gpxFile это часть формата GPX с точками треков. Теги без пространства имён подходят и для GPX 1.0, и для 1.1
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  float64 `xml:"ele"`
+	Time string  `xml:"time"`
+}
+
+// segment это точки сегмента трека, отсортированные по времени.
+// Между сегментами запись трека прерывалась, поэтому координаты интерполируются только внутри сегмента
+type segment []flickruploader.TrackPoint
+
+// readTrack читает сегменты треков из GPX файла. Точки без времени пропускаются
+func readTrack(path string) ([]segment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var data gpxFile
+	if err := xml.NewDecoder(file).Decode(&data); err != nil {
+		return nil, errors.Wrapf(err, "can't parse GPX %q", path)
+	}
+
+	var segments []segment
+	for _, track := range data.Tracks {
+		for _, trackSegment := range track.Segments {
+			var points segment
+			for _, point := range trackSegment.Points {
+				t, err := time.Parse(time.RFC3339, point.Time)
+				if err != nil {
+					continue
+				}
+				points = append(points, flickruploader.TrackPoint{
+					Latitude:  point.Lat,
+					Longitude: point.Lon,
+					Altitude:  point.Ele,
+					Time:      t,
+				})
+			}
+			if len(points) == 0 {
+				continue
+			}
+			sort.SliceStable(points, func(i, j int) bool {
+				return points[i].Time.Before(points[j].Time)
+			})
+			segments = append(segments, points)
+		}
+	}
+	return segments, nil
+}
+
+// locate находит положение в момент t. distance - расстояние по времени до ближайшей записанной точки
+func (s segment) locate(t time.Time, maxGap time.Duration) (point flickruploader.TrackPoint, distance time.Duration, ok bool) {
+	first, last := s[0], s[len(s)-1]
+	switch {
+	case t.Before(first.Time):
+		distance = first.Time.Sub(t)
+		return first, distance, distance <= maxGap
+	case t.After(last.Time):
+		distance = t.Sub(last.Time)
+		return last, distance, distance <= maxGap
+	}
+
+	// первая точка не раньше t
+	i := sort.Search(len(s), func(i int) bool {
+		return !s[i].Time.Before(t)
+	})
+	after := s[i]
+	if after.Time.Equal(t) {
+		return after, 0, true
+	}
+	before := s[i-1]
+
+	gap := after.Time.Sub(before.Time)
+	distance = t.Sub(before.Time)
+	if after.Time.Sub(t) < distance {
+		distance = after.Time.Sub(t)
+	}
+	if gap > maxGap {
+		// трек долго не писался, интерполировать нельзя, но близкая точка подходит
+		if distance > maxGap {
+			return flickruploader.TrackPoint{}, 0, false
+		}
+		if t.Sub(before.Time) <= after.Time.Sub(t) {
+			return before, distance, true
+		}
+		return after, distance, true
+	}
+
+	ratio := float64(t.Sub(before.Time)) / float64(gap)
+	return flickruploader.TrackPoint{
+		Latitude:  before.Latitude + (after.Latitude-before.Latitude)*ratio,
+		Longitude: before.Longitude + (after.Longitude-before.Longitude)*ratio,
+		Altitude:  before.Altitude + (after.Altitude-before.Altitude)*ratio,
+		Time:      t,
+	}, distance, true
+}
@@ -0,0 +1,136 @@
+package metadata
+
+import (
+	"strings"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// Теги EXIF
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagXMP                = 0x02BC
+	tagIPTC               = 0x83BB
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagLensMake           = 0xA433
+	tagLensModel          = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// exifDateLayout формат даты в EXIF
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// parseExif читает EXIF из блока TIFF в meta. Для TIFF файлов возвращает также XMP и IPTC из IFD0
+func parseExif(tiff *tiffReader, meta *flickruploader.Metadata) (xmp, iptc []byte, err error) {
+	ifd0, _, err := tiff.readIFD(tiff.firstIFD)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't read EXIF IFD0")
+	}
+
+	manufacturer, model := tiff.ascii(ifd0[tagMake]), tiff.ascii(ifd0[tagModel])
+	meta.Camera = joinMakeModel(manufacturer, model)
+	if orientation := tiff.uints(ifd0[tagOrientation]); len(orientation) == 1 {
+		meta.Orientation = int(orientation[0])
+	}
+	dateTaken := tiff.ascii(ifd0[tagDateTime])
+	var offset string
+
+	if pointer := tiff.uints(ifd0[tagExifIFD]); len(pointer) == 1 {
+		exifIFD, _, err := tiff.readIFD(pointer[0])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "can't read EXIF SubIFD")
+		}
+		if original := tiff.ascii(exifIFD[tagDateTimeOriginal]); original != "" {
+			dateTaken = original
+			offset = tiff.ascii(exifIFD[tagOffsetTimeOriginal])
+		}
+		meta.Lens = joinMakeModel(tiff.ascii(exifIFD[tagLensMake]), tiff.ascii(exifIFD[tagLensModel]))
+	}
+	meta.DateTaken = parseExifDate(dateTaken, offset)
+
+	if pointer := tiff.uints(ifd0[tagGPSIFD]); len(pointer) == 1 {
+		gpsIFD, _, err := tiff.readIFD(pointer[0])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "can't read GPS IFD")
+		}
+		parseGPS(tiff, gpsIFD, meta)
+	}
+
+	return ifd0[tagXMP].data, ifd0[tagIPTC].data, nil
+}
+
+// parseGPS читает координаты из GPS IFD
+func parseGPS(tiff *tiffReader, gps map[uint16]tiffEntry, meta *flickruploader.Metadata) {
+	latitude, okLatitude := degrees(tiff.rationals(gps[tagGPSLatitude]))
+	longitude, okLongitude := degrees(tiff.rationals(gps[tagGPSLongitude]))
+	if !okLatitude || !okLongitude {
+		return
+	}
+	if tiff.ascii(gps[tagGPSLatitudeRef]) == "S" {
+		latitude = -latitude
+	}
+	if tiff.ascii(gps[tagGPSLongitudeRef]) == "W" {
+		longitude = -longitude
+	}
+	// камеры без фиксации спутников пишут нули
+	if latitude == 0 && longitude == 0 {
+		return
+	}
+
+	meta.HasGPS = true
+	meta.Latitude = latitude
+	meta.Longitude = longitude
+	if altitude := tiff.rationals(gps[tagGPSAltitude]); len(altitude) == 1 {
+		meta.Altitude = altitude[0]
+		if ref := tiff.uints(gps[tagGPSAltitudeRef]); len(ref) == 1 && ref[0] == 1 {
+			meta.Altitude = -meta.Altitude
+		}
+	}
+}
+
+// degrees переводит градусы, минуты, секунды в градусы
+func degrees(dms []float64) (float64, bool) {
+	if len(dms) != 3 {
+		return 0, false
+	}
+	return dms[0] + dms[1]/60 + dms[2]/3600, true
+}
+
+// joinMakeModel склеивает производителя и модель. Многие производители уже пишут себя в модели
+func joinMakeModel(manufacturer, model string) string {
+	if manufacturer == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(manufacturer)) {
+		return model
+	}
+	return strings.TrimSpace(manufacturer + " " + model)
+}
+
+// parseExifDate разбирает дату EXIF. Без смещения часового пояса дата считается локальной
+func parseExifDate(value, offset string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if offset != "" {
+		if t, err := time.Parse(exifDateLayout+"-07:00", value+offset); err == nil {
+			return t
+		}
+	}
+	t, err := time.ParseInLocation(exifDateLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
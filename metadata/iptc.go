@@ -0,0 +1,67 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+)
+
+// Наборы данных IPTC IIM записи 2 (Application Record)
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcHeadline   = 105
+	iptcCaption    = 120
+)
+
+// iptcData это поля IPTC, которые используются программой
+type iptcData struct {
+	objectName string
+	headline   string
+	caption    string
+	keywords   []string
+}
+
+// parseIPTC разбирает записи IPTC IIM
+func parseIPTC(data []byte) iptcData {
+	var result iptcData
+	for len(data) >= 5 && data[0] == 0x1C {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:5]))
+		// расширенный размер не поддерживается, такие поля не бывают текстовыми
+		if size&0x8000 != 0 || 5+size > len(data) {
+			return result
+		}
+		value := iptcString(data[5 : 5+size])
+		data = data[5+size:]
+
+		if record != 2 {
+			continue
+		}
+		switch dataset {
+		case iptcObjectName:
+			result.objectName = value
+		case iptcHeadline:
+			result.headline = value
+		case iptcCaption:
+			result.caption = value
+		case iptcKeywords:
+			if value != "" {
+				result.keywords = append(result.keywords, value)
+			}
+		}
+	}
+	return result
+}
+
+// iptcString декодирует строку IPTC. Современные редакторы пишут UTF-8, старые - Latin-1
+func iptcString(raw []byte) string {
+	if utf8.Valid(raw) {
+		return strings.TrimSpace(string(raw))
+	}
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return strings.TrimSpace(string(runes))
+}
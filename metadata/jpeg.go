@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Сигнатуры сегментов JPEG с метаданными
+var (
+	exifSignature      = []byte("Exif\x00\x00")
+	xmpSignature       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopSignature = []byte("Photoshop 3.0\x00")
+)
+
+// maxSegmentRead ограничение размера читаемого в память сегмента
+const maxSegmentRead = 1 << 20
+
+// sections это блоки метаданных файла
+type sections struct {
+	// exif блок TIFF с EXIF
+	exif io.ReaderAt
+	// xmp XML пакет XMP
+	xmp []byte
+	// iptc записи IPTC-NAA (IIM)
+	iptc []byte
+}
+
+// jpegSections находит сегменты метаданных JPEG: APP1 с EXIF и XMP, APP13 с IPTC
+func jpegSections(file *os.File) sections {
+	var result sections
+	header := make([]byte, 4)
+	for offset := int64(2); ; {
+		if _, err := file.ReadAt(header, offset); err != nil {
+			return result
+		}
+		if header[0] != 0xFF {
+			return result
+		}
+		// заполняющие байты 0xFF перед маркером
+		if header[1] == 0xFF {
+			offset++
+			continue
+		}
+		// сегменты метаданных идут до данных изображения
+		if header[1] == 0xDA || header[1] == 0xD9 {
+			return result
+		}
+
+		length := int64(binary.BigEndian.Uint16(header[2:4]))
+		dataOffset, dataLength := offset+4, length-2
+		if (header[1] == 0xE1 || header[1] == 0xED) && dataLength > 0 && dataLength <= maxSegmentRead {
+			data := make([]byte, dataLength)
+			if _, err := file.ReadAt(data, dataOffset); err != nil {
+				return result
+			}
+			switch {
+			case header[1] == 0xE1 && bytes.HasPrefix(data, exifSignature) && result.exif == nil:
+				result.exif = io.NewSectionReader(file, dataOffset+int64(len(exifSignature)), dataLength-int64(len(exifSignature)))
+			case header[1] == 0xE1 && bytes.HasPrefix(data, xmpSignature) && result.xmp == nil:
+				result.xmp = data[len(xmpSignature):]
+			case header[1] == 0xED && bytes.HasPrefix(data, photoshopSignature) && result.iptc == nil:
+				result.iptc = photoshopIPTC(data[len(photoshopSignature):])
+			}
+		}
+		offset += 2 + length
+	}
+}
+
+// photoshopIPTC находит ресурс IPTC-NAA (0x0404) среди ресурсов Photoshop 8BIM
+func photoshopIPTC(data []byte) []byte {
+	for len(data) >= 12 && string(data[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(data[4:6])
+		// имя ресурса: паскалевская строка, выровненная до чётной длины
+		nameLength := int(data[6]) + 1
+		if nameLength%2 != 0 {
+			nameLength++
+		}
+		pos := 6 + nameLength
+		if pos+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if pos+size > len(data) {
+			return nil
+		}
+		if id == 0x0404 {
+			return data[pos : pos+size]
+		}
+		if size%2 != 0 {
+			size++
+		}
+		if pos+size > len(data) {
+			return nil
+		}
+		data = data[pos+size:]
+	}
+	return nil
+}
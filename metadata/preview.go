@@ -0,0 +1,84 @@
+package metadata
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// EmbeddedPreview находит самое большое встроенное в RAW (или TIFF) файл JPEG превью и возвращает его байты
+func EmbeddedPreview(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tiff, err := newTiffReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		offset, length uint32
+	}
+	var candidates []candidate
+	err = tiff.walkIFDs(func(entries map[uint16]tiffEntry) {
+		// JpgFromRaw (NEF, DNG, ARW)
+		offset, okOffset := entries[tagJPEGInterchange]
+		length, okLength := entries[tagJPEGInterchangeLength]
+		if okOffset && okLength {
+			offsets, lengths := tiff.uints(offset), tiff.uints(length)
+			if len(offsets) == 1 && len(lengths) == 1 {
+				candidates = append(candidates, candidate{offsets[0], lengths[0]})
+			}
+		}
+
+		// превью в виде одной полосы со сжатием JPEG (CR2 IFD0)
+		compression, ok := entries[tagCompression]
+		if !ok {
+			return
+		}
+		if values := tiff.uints(compression); len(values) != 1 || (values[0] != 6 && values[0] != 7) {
+			return
+		}
+		offsets, lengths := tiff.uints(entries[tagStripOffsets]), tiff.uints(entries[tagStripByteCounts])
+		if len(offsets) == 1 && len(lengths) == 1 {
+			candidates = append(candidates, candidate{offsets[0], lengths[0]})
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read RAW structure")
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't stat %q", path)
+	}
+
+	var best []byte
+	for _, c := range candidates {
+		if int(c.length) <= len(best) {
+			continue
+		}
+		// смещение и длина берутся из файла: превью за концом битого файла не читается
+		if uint64(c.offset)+uint64(c.length) > uint64(stat.Size()) {
+			continue
+		}
+		data := make([]byte, c.length)
+		if _, err := file.ReadAt(data, int64(c.offset)); err != nil {
+			continue
+		}
+		// lossless JPEG с данными сенсора тоже начинается с SOI, но стандартная библиотека его не декодирует
+		if _, err := jpeg.DecodeConfig(bytes.NewReader(data)); err != nil {
+			continue
+		}
+		best = data
+	}
+	if best == nil {
+		return nil, errors.New("no embedded JPEG preview found")
+	}
+	return best, nil
+}
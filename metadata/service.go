@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// metadataVersion версия разбора метаданных. Увеличивается при изменении разбора, чтобы перечитать кэш
+const metadataVersion = 2
+
+// Cache кэш хэша содержимого и разобранных метаданных по пути, размеру и времени изменения файла
+type Cache interface {
+	MetadataGet(path string, size, mtime int64, version int) (flickruploader.Metadata, bool, error)
+	MetadataSave(path string, size, mtime int64, version int, meta flickruploader.Metadata) error
+}
+
+// Service читает метаданные EXIF, IPTC и XMP из JPEG и TIFF (в том числе RAW на основе TIFF) файлов
+type Service struct {
+	cache Cache
+}
+
+// NewService создаёт сервис
+func NewService(cache Cache) *Service {
+	return &Service{cache: cache}
+}
+
+// Read возвращает метаданные файла вместе с хэшем содержимого. Для файлов других форматов возвращается
+// только хэш. Пока у файла не изменились размер и время изменения, файл не читается, всё берётся из кэша
+func (s *Service) Read(path string) (flickruploader.Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return flickruploader.Metadata{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return flickruploader.Metadata{}, errors.Wrapf(err, "can't stat %q", path)
+	}
+	size, mtime := info.Size(), info.ModTime().UnixNano()
+	meta, ok, err := s.cache.MetadataGet(path, size, mtime, metadataVersion)
+	if err != nil {
+		log.Printf("Can't get cached metadata of %q: %s", path, err)
+	}
+	if ok {
+		return meta, nil
+	}
+
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return flickruploader.Metadata{}, errors.Wrapf(err, "can't hash %q", path)
+	}
+
+	head := make([]byte, 4)
+	if _, err := file.ReadAt(head, 0); err == nil {
+		switch {
+		case bytes.Equal(head, []byte("II*\x00")) || bytes.Equal(head, []byte("MM\x00*")):
+			meta = parse(sections{exif: file})
+		case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+			meta = parse(jpegSections(file))
+		}
+	}
+	meta.Hash = hex.EncodeToString(hasher.Sum(nil))
+
+	if err := s.cache.MetadataSave(path, size, mtime, metadataVersion, meta); err != nil {
+		log.Printf("Can't cache metadata of %q: %s", path, err)
+	}
+	return meta, nil
+}
+
+// parse разбирает найденные блоки метаданных. XMP имеет приоритет над IPTC
+func parse(found sections) flickruploader.Metadata {
+	var meta flickruploader.Metadata
+	if found.exif != nil {
+		if tiff, err := newTiffReader(found.exif); err == nil {
+			xmp, iptc, err := parseExif(tiff, &meta)
+			if err != nil {
+				log.Printf("Can't read EXIF: %s", err)
+			}
+			// в TIFF файлах XMP и IPTC хранятся в тегах IFD0
+			if found.xmp == nil {
+				found.xmp = xmp
+			}
+			if found.iptc == nil {
+				found.iptc = iptc
+			}
+		}
+	}
+
+	iptc := parseIPTC(found.iptc)
+	xmp := parseXMP(found.xmp)
+
+	meta.Rating = xmp.rating
+	meta.Title = firstNonEmpty(xmp.title, iptc.objectName)
+	meta.Headline = firstNonEmpty(xmp.headline, iptc.headline)
+	meta.Caption = firstNonEmpty(xmp.description, iptc.caption)
+	meta.Keywords = uniqueKeywords(append(iptc.keywords, xmp.keywords...))
+	// координаты из XMP используются, если их нет в EXIF: редакторы и телефоны пишут их только в XMP
+	if !meta.HasGPS && xmp.hasLatitude && xmp.hasLongitude && (xmp.latitude != 0 || xmp.longitude != 0) {
+		meta.HasGPS = true
+		meta.Latitude = xmp.latitude
+		meta.Longitude = xmp.longitude
+	}
+	return meta
+}
+
+// firstNonEmpty возвращает первое непустое значение
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// uniqueKeywords удаляет повторы ключевых слов без учёта регистра, сохраняя порядок
+func uniqueKeywords(keywords []string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, keyword := range keywords {
+		key := strings.ToLower(keyword)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, keyword)
+	}
+	return result
+}
@@ -0,0 +1,208 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Теги TIFF, которые нужны для поиска встроенных превью
+const (
+	tagCompression           = 0x0103
+	tagStripOffsets          = 0x0111
+	tagStripByteCounts       = 0x0117
+	tagSubIFDs               = 0x014A
+	tagJPEGInterchange       = 0x0201
+	tagJPEGInterchangeLength = 0x0202
+)
+
+// maxIFDs ограничение на количество читаемых IFD, защита от зацикленных ссылок в битых файлах
+const maxIFDs = 64
+
+// maxEntrySize ограничение на размер значения записи IFD, больше не бывает в нужных тегах
+const maxEntrySize = 1 << 20
+
+// tiffEntry это запись IFD
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+	// firstIFD смещение первого IFD
+	firstIFD uint32
+}
+
+// newTiffReader читает заголовок TIFF начиная с начала r
+func newTiffReader(r io.ReaderAt) (*tiffReader, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, errors.Wrap(err, "can't read TIFF header")
+	}
+
+	t := &tiffReader{r: r}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF file")
+	}
+	t.firstIFD = t.order.Uint32(header[4:8])
+	return t, nil
+}
+
+// typeSize размер одного значения TIFF типа в байтах
+func typeSize(typ uint16) uint32 {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11, 13: // LONG, SLONG, FLOAT, IFD
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	}
+	return 0
+}
+
+// readIFD читает IFD по смещению offset. Возвращает записи и смещение следующего IFD
+func (t *tiffReader) readIFD(offset uint32) (map[uint16]tiffEntry, uint32, error) {
+	countBuf := make([]byte, 2)
+	if _, err := t.r.ReadAt(countBuf, int64(offset)); err != nil {
+		return nil, 0, errors.Wrapf(err, "can't read IFD at %d", offset)
+	}
+	count := int(t.order.Uint16(countBuf))
+
+	buf := make([]byte, count*12+4)
+	if _, err := t.r.ReadAt(buf, int64(offset)+2); err != nil {
+		return nil, 0, errors.Wrapf(err, "can't read IFD entries at %d", offset)
+	}
+
+	entries := map[uint16]tiffEntry{}
+	for i := 0; i < count; i++ {
+		raw := buf[i*12 : i*12+12]
+		entry := tiffEntry{
+			typ:   t.order.Uint16(raw[2:4]),
+			count: t.order.Uint32(raw[4:8]),
+		}
+		// в uint64 размер не переполняется при любом количестве из файла
+		size := uint64(typeSize(entry.typ)) * uint64(entry.count)
+		if size == 0 || size > maxEntrySize {
+			continue
+		}
+		if size <= 4 {
+			entry.data = raw[8 : 8+size]
+		} else {
+			// значение за концом файла не читается полностью, такая запись пропускается
+			entry.data = make([]byte, size)
+			if _, err := t.r.ReadAt(entry.data, int64(t.order.Uint32(raw[8:12]))); err != nil {
+				continue
+			}
+		}
+		entries[t.order.Uint16(raw[0:2])] = entry
+	}
+
+	return entries, t.order.Uint32(buf[count*12:]), nil
+}
+
+// valueCount возвращает количество значений записи, которые есть в её данных
+func valueCount(entry tiffEntry) int {
+	size := int(typeSize(entry.typ))
+	if size == 0 {
+		return 0
+	}
+	count := len(entry.data) / size
+	if uint64(entry.count) < uint64(count) {
+		count = int(entry.count)
+	}
+	return count
+}
+
+// uints возвращает целочисленные значения записи (BYTE, SHORT, LONG, IFD)
+func (t *tiffReader) uints(entry tiffEntry) []uint32 {
+	var values []uint32
+	size := int(typeSize(entry.typ))
+	for i := 0; i < valueCount(entry); i++ {
+		raw := entry.data[i*size : (i+1)*size]
+		switch entry.typ {
+		case 1, 7:
+			values = append(values, uint32(raw[0]))
+		case 3:
+			values = append(values, uint32(t.order.Uint16(raw)))
+		case 4, 13:
+			values = append(values, t.order.Uint32(raw))
+		default:
+			return values
+		}
+	}
+	return values
+}
+
+// ascii возвращает строковое значение записи без завершающих нулей и пробелов
+func (t *tiffReader) ascii(entry tiffEntry) string {
+	if entry.typ != 2 {
+		return ""
+	}
+	value := string(entry.data)
+	if i := strings.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// rationals возвращает значения записи типа RATIONAL или SRATIONAL
+func (t *tiffReader) rationals(entry tiffEntry) []float64 {
+	if entry.typ != 5 && entry.typ != 10 {
+		return nil
+	}
+	var values []float64
+	for i := 0; i < valueCount(entry); i++ {
+		raw := entry.data[i*8 : (i+1)*8]
+		numerator, denominator := t.order.Uint32(raw[:4]), t.order.Uint32(raw[4:])
+		if denominator == 0 {
+			values = append(values, 0)
+			continue
+		}
+		if entry.typ == 10 {
+			values = append(values, float64(int32(numerator))/float64(int32(denominator)))
+			continue
+		}
+		values = append(values, float64(numerator)/float64(denominator))
+	}
+	return values
+}
+
+// walkIFDs обходит цепочку IFD начиная с первого, включая SubIFDs, и вызывает visit для каждого
+func (t *tiffReader) walkIFDs(visit func(entries map[uint16]tiffEntry)) error {
+	queue := []uint32{t.firstIFD}
+	visited := map[uint32]bool{}
+
+	for len(queue) > 0 && len(visited) < maxIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		entries, next, err := t.readIFD(offset)
+		if err != nil {
+			return err
+		}
+		visit(entries)
+
+		if subIFDs, ok := entries[tagSubIFDs]; ok {
+			queue = append(queue, t.uints(subIFDs)...)
+		}
+		queue = append(queue, next)
+	}
+	return nil
+}
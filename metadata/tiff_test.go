@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/denisov/flickr-uploader-go"
+)
+
+// ifdEntry это запись IFD для сборки тестового TIFF
+type ifdEntry struct {
+	tag, typ     uint16
+	count, value uint32
+}
+
+// buildTIFF собирает little-endian TIFF с одним IFD по смещению 8. declared - количество записей в заголовке IFD,
+// оно может не совпадать с количеством записей entries
+func buildTIFF(declared uint16, entries []ifdEntry) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("II")
+	binary.Write(buf, binary.LittleEndian, uint16(42))
+	binary.Write(buf, binary.LittleEndian, uint32(8))
+	binary.Write(buf, binary.LittleEndian, declared)
+	for _, entry := range entries {
+		binary.Write(buf, binary.LittleEndian, entry)
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func TestParseExifDamagedIFD(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+		want    flickruploader.Metadata
+	}{
+		{
+			name: "valid orientation",
+			data: buildTIFF(1, []ifdEntry{{tag: tagOrientation, typ: 3, count: 1, value: 6}}),
+			want: flickruploader.Metadata{Orientation: 6},
+		},
+		{
+			name:    "truncated entry list",
+			data:    buildTIFF(10, []ifdEntry{{tag: tagOrientation, typ: 3, count: 1, value: 6}}),
+			wantErr: true,
+		},
+		{
+			// 4 * 0x40000001 переполняет uint32 и даёт размер 4
+			name: "oversized LONG count",
+			data: buildTIFF(1, []ifdEntry{{tag: tagOrientation, typ: 4, count: 0x40000001, value: 6}}),
+		},
+		{
+			name: "oversized SHORT count",
+			data: buildTIFF(1, []ifdEntry{{tag: tagOrientation, typ: 3, count: 0x80000001, value: 6}}),
+		},
+		{
+			name: "EXIF pointer with oversized count",
+			data: buildTIFF(1, []ifdEntry{{tag: tagExifIFD, typ: 4, count: 0x40000001, value: 8}}),
+		},
+		{
+			name: "value past the end of file",
+			data: buildTIFF(1, []ifdEntry{{tag: tagMake, typ: 2, count: 64, value: 4096}}),
+		},
+		{
+			name: "GPS pointer with oversized count",
+			data: buildTIFF(1, []ifdEntry{{tag: tagGPSIFD, typ: 4, count: 0x40000001, value: 8}}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tiff, err := newTiffReader(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatalf("newTiffReader: %s", err)
+			}
+			var meta flickruploader.Metadata
+			_, _, err = parseExif(tiff, &meta)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseExif error = %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && meta.Orientation != test.want.Orientation {
+				t.Errorf("orientation = %d, want %d", meta.Orientation, test.want.Orientation)
+			}
+		})
+	}
+}
+
+func TestTiffValuesBoundedByData(t *testing.T) {
+	tiff := &tiffReader{order: binary.LittleEndian}
+	tests := []struct {
+		name  string
+		entry tiffEntry
+		uints int
+		rats  int
+	}{
+		{name: "count larger than data", entry: tiffEntry{typ: 4, count: 0x40000001, data: make([]byte, 4)}, uints: 1},
+		{name: "short rational data", entry: tiffEntry{typ: 5, count: 3, data: make([]byte, 12)}, rats: 1},
+		{name: "unknown type", entry: tiffEntry{typ: 99, count: 5, data: make([]byte, 20)}},
+		{name: "count smaller than data", entry: tiffEntry{typ: 3, count: 1, data: make([]byte, 4)}, uints: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := len(tiff.uints(test.entry)); got != test.uints {
+				t.Errorf("uints returned %d values, want %d", got, test.uints)
+			}
+			if got := len(tiff.rationals(test.entry)); got != test.rats {
+				t.Errorf("rationals returned %d values, want %d", got, test.rats)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// Пространства имён XMP
+const (
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsEXIF      = "http://ns.adobe.com/exif/1.0/"
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// xmpData это поля XMP, которые используются программой
+type xmpData struct {
+	rating      int
+	title       string
+	headline    string
+	description string
+	keywords    []string
+	// latitude и longitude координаты exif:GPSLatitude и exif:GPSLongitude, если они есть (hasLatitude, hasLongitude)
+	latitude, longitude       float64
+	hasLatitude, hasLongitude bool
+}
+
+// parseXMP разбирает XMP пакет. Ошибки разбора не возвращаются: используется то, что удалось прочитать
+func parseXMP(data []byte) xmpData {
+	var result xmpData
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	var stack []xml.Name
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return result
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, token.Name)
+			text.Reset()
+			// простые свойства могут быть записаны атрибутами rdf:Description
+			for _, attr := range token.Attr {
+				result.setSimple(attr.Name, attr.Value)
+			}
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			result.setElement(stack, value)
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// setSimple записывает простое свойство
+func (x *xmpData) setSimple(name xml.Name, value string) {
+	value = strings.TrimSpace(value)
+	switch {
+	case name.Space == nsXMP && name.Local == "Rating":
+		if rating, err := strconv.ParseFloat(value, 64); err == nil {
+			x.rating = int(rating)
+		}
+	case name.Space == nsPhotoshop && name.Local == "Headline":
+		x.headline = value
+	case name.Space == nsEXIF && name.Local == "GPSLatitude":
+		x.latitude, x.hasLatitude = xmpCoordinate(value)
+	case name.Space == nsEXIF && name.Local == "GPSLongitude":
+		x.longitude, x.hasLongitude = xmpCoordinate(value)
+	}
+}
+
+// xmpCoordinate разбирает координату XMP вида "DDD,MM,SSk" или "DDD,MM.mmk", где k - N, S, E или W
+func xmpCoordinate(value string) (float64, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	parts := strings.Split(value[:len(value)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var coordinate float64
+	divisor := 1.0
+	for _, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, false
+		}
+		coordinate += number / divisor
+		divisor *= 60
+	}
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "N", "E":
+		return coordinate, true
+	case "S", "W":
+		return -coordinate, true
+	}
+	return 0, false
+}
+
+// setElement записывает значение закрывающегося элемента. stack - путь до элемента включительно
+func (x *xmpData) setElement(stack []xml.Name, value string) {
+	current := stack[len(stack)-1]
+	if value == "" {
+		return
+	}
+	if current.Space != nsRDF || current.Local != "li" {
+		x.setSimple(current, value)
+		return
+	}
+
+	// элементы массивов: dc:subject/rdf:Bag/rdf:li, dc:title/rdf:Alt/rdf:li
+	if len(stack) < 3 {
+		return
+	}
+	property := stack[len(stack)-3]
+	if property.Space != nsDC {
+		return
+	}
+	switch property.Local {
+	case "subject":
+		x.keywords = append(x.keywords, value)
+	case "title":
+		// первый элемент rdf:Alt это x-default
+		if x.title == "" {
+			x.title = value
+		}
+	case "description":
+		if x.description == "" {
+			x.description = value
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package photofiles
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Файлы с настройками альбома в директории с фото. .album.yml имеет приоритет
+const (
+	albumYAML = ".album.yml"
+	albumText = "album.txt"
+)
+
+// GetAlbum читает настройки альбома директории dir (относительно директории с фото, пустая строка - корень).
+// album.txt: первая строка - название, остальные - описание. ok false если файла нет
+func (s *Service) GetAlbum(dir string) (album flickruploader.Album, ok bool, err error) {
+	base := filepath.Join(s.path, filepath.FromSlash(dir))
+
+	data, err := ioutil.ReadFile(filepath.Join(base, albumYAML))
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &album); err != nil {
+			return album, false, errors.Wrapf(err, "can't parse %s in %q", albumYAML, dir)
+		}
+	case os.IsNotExist(err):
+		data, err = ioutil.ReadFile(filepath.Join(base, albumText))
+		if os.IsNotExist(err) {
+			return album, false, nil
+		}
+		if err != nil {
+			return album, false, errors.Wrapf(err, "can't read %s in %q", albumText, dir)
+		}
+		lines := strings.SplitN(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n", 2)
+		album.Title = lines[0]
+		if len(lines) > 1 {
+			album.Description = lines[1]
+		}
+	default:
+		return album, false, errors.Wrapf(err, "can't read %s in %q", albumYAML, dir)
+	}
+
+	album.Title = strings.TrimSpace(album.Title)
+	album.Description = strings.TrimSpace(album.Description)
+	album.Cover = strings.TrimSpace(album.Cover)
+	album.Sort = strings.TrimSpace(album.Sort)
+	sum := sha1.Sum(data)
+	album.Hash = hex.EncodeToString(sum[:])
+	return album, true, nil
+}
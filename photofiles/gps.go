@@ -0,0 +1,232 @@
+package photofiles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// gpsIFDTag тег IFD0 со смещением GPS IFD
+const gpsIFDTag = 0x8825
+
+// exifTypeSizes размеры значений типов TIFF в байтах
+var exifTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// copyWithoutGPS копирует JPEG файл path во временную директорию и удаляет из копии координаты EXIF и XMP.
+// Файлы других форматов и JPEG без координат не копируются: возвращается path и пустой cleanup
+func copyWithoutGPS(path string) (uploadPath string, cleanup func(), err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "can't read %q", path)
+	}
+	stripped, err := stripGPS(data)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "can't remove GPS from %q", path)
+	}
+	if !stripped {
+		return path, func() {}, nil
+	}
+
+	dir, err := ioutil.TempDir("", "flickr-uploader-go")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "can't create temp dir")
+	}
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+	uploadPath = filepath.Join(dir, filepath.Base(path))
+	if err := ioutil.WriteFile(uploadPath, data, 0600); err != nil {
+		cleanup()
+		return "", nil, errors.Wrap(err, "can't write copy without GPS")
+	}
+	return uploadPath, cleanup, nil
+}
+
+// stripGPS удаляет координаты из EXIF и XMP JPEG файла data, не меняя размер файла. Остальные метаданные
+// не меняются. Возвращает false, если это не JPEG или в нём нет координат
+func stripGPS(data []byte) (bool, error) {
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return false, nil
+	}
+	exifStripped, err := stripExifGPS(data)
+	if err != nil {
+		return false, err
+	}
+	xmpStripped := false
+	for _, segment := range appSegments(data, 0xE1) {
+		if bytes.HasPrefix(segment, xmpPrefix) || bytes.HasPrefix(segment, xmpExtensionPrefix) {
+			xmpStripped = stripXMPGPS(segment) || xmpStripped
+		}
+	}
+	return exifStripped || xmpStripped, nil
+}
+
+// Заголовки сегментов APP1 с XMP: основной пакет и продолжение расширенного XMP
+var (
+	xmpPrefix          = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtensionPrefix = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// Свойства XMP с координатами: exif:GPSLatitude, exif:GPSLongitude и другие свойства GPS любого пространства имён
+// (например drone-dji:GpsLatitude) в виде атрибута или элемента
+var (
+	xmpGPSAttribute = regexp.MustCompile(`[\w.-]+:(?i:gps)\w*\s*=\s*("[^"]*"|'[^']*')`)
+	xmpGPSElement   = regexp.MustCompile(`(?s)<([\w.-]+:(?i:gps)\w*)(\s*|\s[^>]*[^/>])>(.*?)</([\w.-]+:(?i:gps)\w*)\s*>`)
+)
+
+// stripXMPGPS заменяет пробелами значения свойств XMP с координатами в сегменте segment. Размер сегмента
+// не меняется, XML остаётся корректным. Возвращает false, если координат нет
+func stripXMPGPS(segment []byte) bool {
+	stripped := false
+	for _, match := range xmpGPSAttribute.FindAllSubmatchIndex(segment, -1) {
+		// объявление пространства имён не координата
+		if bytes.HasPrefix(segment[match[0]:], []byte("xmlns:")) {
+			continue
+		}
+		// значение без кавычек
+		if blank(segment[match[2]+1 : match[3]-1]) {
+			stripped = true
+		}
+	}
+	for _, match := range xmpGPSElement.FindAllSubmatchIndex(segment, -1) {
+		if blank(segment[match[6]:match[7]]) {
+			stripped = true
+		}
+	}
+	return stripped
+}
+
+// blank заменяет пробелами все символы кроме пробельных. Возвращает false, если менять было нечего
+func blank(b []byte) bool {
+	changed := false
+	for i, c := range b {
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			b[i] = ' '
+			changed = true
+		}
+	}
+	return changed
+}
+
+// stripExifGPS затирает нулями записи GPS IFD в EXIF JPEG файла data и их значения, оставляя пустой GPS IFD.
+// Возвращает false, если в EXIF нет координат
+func stripExifGPS(data []byte) (bool, error) {
+	segments := appSegments(data, 0xE1)
+	var tiff []byte
+	for _, segment := range segments {
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			tiff = segment[6:]
+			break
+		}
+	}
+	if len(tiff) < 8 {
+		return false, nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return false, errors.New("invalid TIFF byte order")
+	}
+
+	ifd0, err := ifdEntries(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		return false, err
+	}
+	var gpsOffset uint32
+	for _, entry := range ifd0 {
+		if order.Uint16(entry[0:2]) == gpsIFDTag {
+			gpsOffset = order.Uint32(entry[8:12])
+		}
+	}
+	if gpsOffset == 0 {
+		return false, nil
+	}
+
+	gps, err := ifdEntries(tiff, order, gpsOffset)
+	if err != nil {
+		return false, err
+	}
+	if len(gps) == 0 {
+		return false, nil
+	}
+	for _, entry := range gps {
+		size, known := exifTypeSizes[order.Uint16(entry[2:4])]
+		if known && uint64(size)*uint64(order.Uint32(entry[4:8])) > 4 {
+			offset := uint64(order.Uint32(entry[8:12]))
+			end := offset + uint64(size)*uint64(order.Uint32(entry[4:8]))
+			if end > uint64(len(tiff)) {
+				return false, errors.New("GPS value is out of EXIF")
+			}
+			zero(tiff[offset:end])
+		}
+		zero(entry)
+	}
+	order.PutUint16(tiff[gpsOffset:], 0)
+	return true, nil
+}
+
+// appSegments возвращает данные сегментов JPEG с маркером marker до начала данных изображения.
+// Срезы ссылаются на data
+func appSegments(data []byte, marker byte) [][]byte {
+	var segments [][]byte
+	for offset := 2; offset+4 <= len(data); {
+		if data[offset] != 0xFF {
+			return segments
+		}
+		current := data[offset+1]
+		// заполняющие байты 0xFF перед маркером
+		if current == 0xFF {
+			offset++
+			continue
+		}
+		// метаданные идут до данных изображения
+		if current == 0xDA || current == 0xD9 {
+			return segments
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return segments
+		}
+		if current == marker {
+			segments = append(segments, data[offset+4:end])
+		}
+		offset = end
+	}
+	return segments
+}
+
+// ifdEntries возвращает записи IFD по смещению offset от начала TIFF данных. Срезы ссылаются на tiff
+func ifdEntries(tiff []byte, order binary.ByteOrder, offset uint32) ([][]byte, error) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(tiff) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	entries := make([][]byte, count)
+	for i := range entries {
+		entries[i] = tiff[start+i*12 : start+i*12+12]
+	}
+	return entries, nil
+}
+
+// zero заполняет срез нулями
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,88 @@
+package photofiles
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/denisov/flickr-uploader-go"
+)
+
+// sniffLength сколько байт из начала файла читаем для определения типа
+const sniffLength = 200
+
+// detectMediaType определяет тип медиафайла по сигнатуре (magic bytes) в начале файла
+func detectMediaType(path string) (flickruploader.MediaType, error) {
+	head, err := readHead(path)
+	if err != nil {
+		return flickruploader.MediaUnknown, err
+	}
+	return sniffMediaType(head), nil
+}
+
+// readHead читает первые sniffLength байт файла
+func readHead(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// imageFormat определяет формат изображения по первым байтам файла, пустая строка если это не изображение
+func imageFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "gif"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "tiff"
+	}
+	return ""
+}
+
+// sniffMediaType определяет тип по первым байтам файла
+func sniffMediaType(head []byte) flickruploader.MediaType {
+	if imageFormat(head) != "" {
+		return flickruploader.MediaPhoto
+	}
+
+	switch {
+	case len(head) >= 12 && isQuickTimeBox(head[4:8]): // MP4, MOV, M4V, 3GP
+		return flickruploader.MediaVideo
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("AVI ")):
+		return flickruploader.MediaVideo
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}): // Matroska, WebM
+		return flickruploader.MediaVideo
+	case bytes.HasPrefix(head, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}): // ASF, WMV
+		return flickruploader.MediaVideo
+	case bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0xBA}): // MPEG program stream
+		return flickruploader.MediaVideo
+	case len(head) >= 189 && head[0] == 0x47 && head[188] == 0x47: // MPEG transport stream
+		return flickruploader.MediaVideo
+	case len(head) >= 197 && head[4] == 0x47 && head[196] == 0x47: // AVCHD .mts/.m2ts
+		return flickruploader.MediaVideo
+	}
+
+	return flickruploader.MediaUnknown
+}
+
+// isQuickTimeBox проверяет что это тип одного из первых атомов ISO BMFF / QuickTime файла
+func isQuickTimeBox(boxType []byte) bool {
+	for _, t := range []string{"ftyp", "moov", "mdat", "wide", "free", "skip"} {
+		if string(boxType) == t {
+			return true
+		}
+	}
+	return false
+}
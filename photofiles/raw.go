@@ -0,0 +1,52 @@
+package photofiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go/metadata"
+	"github.com/pkg/errors"
+)
+
+// Политики обработки RAW файлов
+const (
+	// RawPolicyJPEG загружать только JPEG из пары, одиночные RAW игнорируются
+	RawPolicyJPEG = "jpeg"
+	// RawPolicyPreview если JPEG в паре нет, загружать встроенное в RAW превью
+	RawPolicyPreview = "preview"
+)
+
+// DefaultRawExtensions расширения RAW файлов основанных на TIFF
+var DefaultRawExtensions = []string{"cr2", "nef", "nrw", "arw", "dng", "orf", "rw2", "pef", "srw"}
+
+// pairKey ключ для поиска пары RAW+JPEG: директория и имя файла без расширения в нижнем регистре
+func pairKey(path string) string {
+	return strings.ToLower(strings.TrimSuffix(path, filepath.Ext(path)))
+}
+
+// writeRawPreview сохраняет превью RAW файла во временную директорию.
+// Имя файла совпадает с именем RAW, чтобы название на flickr было тем же
+func writeRawPreview(path string) (previewPath string, cleanup func(), err error) {
+	preview, err := metadata.EmbeddedPreview(path)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "can't extract preview from %q", path)
+	}
+
+	dir, err := ioutil.TempDir("", "flickr-uploader-go")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "can't create temp dir")
+	}
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".jpg"
+	previewPath = filepath.Join(dir, name)
+	if err := ioutil.WriteFile(previewPath, preview, 0600); err != nil {
+		cleanup()
+		return "", nil, errors.Wrap(err, "can't write preview")
+	}
+	return previewPath, cleanup, nil
+}
@@ -0,0 +1,168 @@
+package photofiles
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CheckReady проверяет что файлы дописаны и их можно загружать: файл не менялся последние MinFileAge,
+// его размер не изменился за StableCheckDelay и JPEG не обрезан. Пауза StableCheckDelay одна на все файлы.
+// Возвращает причины для файлов, которые не готовы. Файл с расширением JPEG, содержимое которого не JPEG,
+// считается готовым: его отбраковывает Validate
+func (s *Service) CheckReady(paths []string) map[string]error {
+	notReady := map[string]error{}
+	// sizes размеры проверяемых файлов: фото и их RAW, owners - фото, к которому относится файл
+	sizes := map[string]int64{}
+	owners := map[string]string{}
+	for _, path := range paths {
+		files := []string{path}
+		if rawPath := s.rawPaths[path]; rawPath != "" && rawPath != path {
+			files = append(files, rawPath)
+		}
+		for _, p := range files {
+			info, err := os.Stat(p)
+			if err != nil {
+				notReady[path] = errors.Wrapf(err, "can't stat %q", p)
+				break
+			}
+			if age := time.Since(info.ModTime()); age < s.options.MinFileAge {
+				notReady[path] = errors.Errorf("%q was modified %s ago", p, age.Round(time.Second))
+				break
+			}
+			sizes[p] = info.Size()
+			owners[p] = path
+		}
+	}
+
+	if s.options.StableCheckDelay > 0 && len(sizes) > 0 {
+		time.Sleep(s.options.StableCheckDelay)
+		for p, size := range sizes {
+			path := owners[p]
+			if notReady[path] != nil {
+				continue
+			}
+			info, err := os.Stat(p)
+			if err != nil {
+				notReady[path] = errors.Wrapf(err, "can't stat %q", p)
+				continue
+			}
+			if info.Size() != size {
+				notReady[path] = errors.Errorf("size of %q is changing", p)
+			}
+		}
+	}
+
+	for _, path := range paths {
+		if notReady[path] != nil {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".jpg" && ext != ".jpeg" {
+			continue
+		}
+		head, err := readHead(path)
+		if err != nil {
+			notReady[path] = errors.Wrapf(err, "can't check %q", path)
+			continue
+		}
+		if imageFormat(head) != "jpeg" {
+			continue
+		}
+		complete, err := jpegComplete(path)
+		if err != nil {
+			notReady[path] = errors.Wrapf(err, "can't check %q", path)
+			continue
+		}
+		if !complete {
+			notReady[path] = errors.Errorf("%q is truncated, no EOI marker", path)
+		}
+	}
+	return notReady
+}
+
+// jpegComplete проверяет что в JPEG после начала данных изображения (SOS) есть маркер конца (EOI).
+// Маркер ищется не только в конце файла: камеры и телефоны дописывают данные после EOI
+func jpegComplete(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
+		return false, nil
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return false, errors.New("not a JPEG")
+	}
+
+	// пропускаем сегменты до SOS, в них (например в EXIF превью) может быть свой EOI
+	for {
+		marker, err := readMarker(r)
+		if err != nil {
+			return false, nil
+		}
+		if marker == 0xD9 {
+			return true, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return false, nil
+		}
+		if _, err := r.Discard(int(length[0])<<8 | int(length[1]) - 2); err != nil {
+			return false, nil
+		}
+		if marker == 0xDA {
+			break
+		}
+	}
+
+	// данные изображения: 0xFF в них всегда экранируется 0x00, маркеры RST пропускаем
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false, nil
+		}
+		if b != 0xFF {
+			continue
+		}
+		b, err = r.ReadByte()
+		if err != nil {
+			return false, nil
+		}
+		if b == 0xD9 {
+			return true, nil
+		}
+		if b == 0xFF {
+			r.UnreadByte()
+		}
+	}
+}
+
+// readMarker читает маркер сегмента JPEG, пропуская заполняющие байты 0xFF
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errors.New("marker expected")
+	}
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
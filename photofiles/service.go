@@ -4,28 +4,86 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/denisov/flickr-uploader-go"
 	"github.com/pkg/errors"
 )
 
+// DefaultExtensions расширения файлов, которые рассматриваются если в конфиге ничего не задано
+var DefaultExtensions = []string{"jpg", "jpeg", "png", "gif", "tif", "tiff", "mp4", "mov", "m4v", "3gp", "avi", "mts", "m2ts"}
+
+// Options это настройки сервиса фотофайлов
+type Options struct {
+	// Extensions расширения файлов (без точки, регистр не важен), которые проверяются на медиафайл
+	Extensions []string
+	// VideoMaxSize максимальный размер видео в байтах, 0 - без ограничения
+	VideoMaxSize int64
+	// VideoMaxDuration максимальная длительность видео, 0 - без ограничения
+	VideoMaxDuration time.Duration
+	// RawExtensions расширения RAW файлов, которые ищутся в пару к JPEG
+	RawExtensions []string
+	// RawPolicy что делать с RAW файлами: RawPolicyJPEG или RawPolicyPreview
+	RawPolicy string
+	// MinFileAge файлы изменённые позже не загружаются, возможно они ещё копируются
+	MinFileAge time.Duration
+	// StableCheckDelay пауза между двумя проверками размера файла перед загрузкой, 0 - не проверять
+	StableCheckDelay time.Duration
+	// Validate режим проверки изображений перед загрузкой: ValidateOff, ValidateHeader или ValidateFull
+	Validate string
+}
+
 type Service struct {
-	path        string
-	excludeDirs []string
+	path          string
+	excludeDirs   []string
+	extensions    map[string]bool
+	rawExtensions map[string]bool
+	options       Options
+
+	mediaTypes map[string]flickruploader.MediaType
+	// rawPaths RAW файл для каждого фото: парный RAW для JPEG или сам RAW если JPEG нет
+	rawPaths map[string]string
 }
 
 // NewService создаёт сервис для доступа к фотофайлам
-func NewService(path string, excludeDirs []string) *Service {
+func NewService(path string, excludeDirs []string, options Options) *Service {
+	if len(options.Extensions) == 0 {
+		options.Extensions = DefaultExtensions
+	}
+	if len(options.RawExtensions) == 0 {
+		options.RawExtensions = DefaultRawExtensions
+	}
+	if options.RawPolicy == "" {
+		options.RawPolicy = RawPolicyJPEG
+	}
+
 	return &Service{
-		path:        path,
-		excludeDirs: excludeDirs,
+		path:          path,
+		excludeDirs:   excludeDirs,
+		extensions:    extensionSet(options.Extensions),
+		rawExtensions: extensionSet(options.RawExtensions),
+		options:       options,
+		mediaTypes:    map[string]flickruploader.MediaType{},
+		rawPaths:      map[string]string{},
+	}
+}
+
+// extensionSet преобразует список расширений в множество вида ".jpg"
+func extensionSet(extensions []string) map[string]bool {
+	set := map[string]bool{}
+	for _, ext := range extensions {
+		set["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
 	}
+	return set
 }
 
-// GetAllPhotos возвращает все фотографии с путями по алфавиту
+// GetAllPhotos возвращает все медиафайлы (фото и видео) с путями по алфавиту.
+// RAW файлы объединяются в пары с JPEG по имени файла, одиночные RAW возвращаются только с политикой RawPolicyPreview
 func (s *Service) GetAllPhotos() ([]string, error) {
 	var photos []string
+	var raws []string
 
 	if _, err := os.Stat(s.path); os.IsNotExist(err) {
 		return nil, errors.Wrapf(err, "Can't read dir: %s", s.path)
@@ -40,16 +98,152 @@ func (s *Service) GetAllPhotos() ([]string, error) {
 			return nil
 		}
 
-		if strings.ToLower(filepath.Ext(path)) != ".jpg" {
+		ext := strings.ToLower(filepath.Ext(path))
+		if s.rawExtensions[ext] {
+			raws = append(raws, path)
+			return nil
+		}
+		if !s.extensions[ext] {
+			return nil
+		}
+
+		// расширение только кандидат, решает содержимое файла
+		mediaType, err := detectMediaType(path)
+		if err != nil {
+			log.Printf("Can't detect media type of %q: %s", path, err)
+			return nil
+		}
+		if mediaType == flickruploader.MediaUnknown {
+			log.Printf("Skip %q: content is not a supported photo or video", path)
 			return nil
 		}
+		s.mediaTypes[path] = mediaType
 
 		photos = append(photos, path)
 		return nil
 	}
 	err := filepath.Walk(s.path, visit)
+	if err != nil {
+		return nil, err
+	}
+
+	photos = s.pairRaws(photos, raws)
+	sort.Strings(photos)
+
+	return photos, nil
+}
+
+// pairRaws сопоставляет RAW файлы с JPEG из той же директории с тем же именем
+func (s *Service) pairRaws(photos, raws []string) []string {
+	jpegs := map[string]string{}
+	for _, path := range photos {
+		if s.mediaTypes[path] != flickruploader.MediaPhoto {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == ".jpg" || ext == ".jpeg" {
+			jpegs[pairKey(path)] = path
+		}
+	}
+
+	for _, rawPath := range raws {
+		if jpegPath, ok := jpegs[pairKey(rawPath)]; ok {
+			s.rawPaths[jpegPath] = rawPath
+			continue
+		}
+		if s.options.RawPolicy != RawPolicyPreview {
+			log.Printf("Skip RAW %q: there is no JPEG for it", rawPath)
+			continue
+		}
+		s.rawPaths[rawPath] = rawPath
+		s.mediaTypes[rawPath] = flickruploader.MediaPhoto
+		photos = append(photos, rawPath)
+	}
+
+	return photos
+}
+
+// GetRawPath возвращает RAW файл относящийся к фото или пустую строку
+func (s *Service) GetRawPath(path string) string {
+	return s.rawPaths[path]
+}
+
+// PrepareUpload возвращает путь к файлу, который надо загрузить на flickr для фото path.
+// Для одиночного RAW это извлечённое во временную директорию превью. Если stripGPS, загружается копия JPEG
+// без координат EXIF и XMP, чтобы Flickr не опубликовал их до установки местоположения. cleanup удаляет временные файлы
+func (s *Service) PrepareUpload(path string, stripGPS bool) (uploadPath string, cleanup func(), err error) {
+	uploadPath, cleanup = path, func() {}
+	if s.rawPaths[path] == path {
+		uploadPath, cleanup, err = writeRawPreview(path)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if !stripGPS {
+		return uploadPath, cleanup, nil
+	}
+
+	strippedPath, cleanupStripped, err := copyWithoutGPS(uploadPath)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	cleanupPreview := cleanup
+	return strippedPath, func() {
+		cleanupStripped()
+		cleanupPreview()
+	}, nil
+}
+
+// Stat возвращает информацию о файле
+func (s *Service) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// Exists проверяет что файл существует
+func (s *Service) Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// GetMediaType возвращает тип медиафайла определённый по его содержимому
+func (s *Service) GetMediaType(path string) (flickruploader.MediaType, error) {
+	if mediaType, ok := s.mediaTypes[path]; ok {
+		return mediaType, nil
+	}
+	mediaType, err := detectMediaType(path)
+	if err != nil {
+		return flickruploader.MediaUnknown, err
+	}
+	s.mediaTypes[path] = mediaType
+	return mediaType, nil
+}
+
+// CheckVideoLimits проверяет что видео не превышает ограничения по размеру и длительности
+func (s *Service) CheckVideoLimits(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "can't stat video %q", path)
+	}
+	if s.options.VideoMaxSize > 0 && info.Size() > s.options.VideoMaxSize {
+		return errors.Errorf("video size %d bytes exceeds limit %d bytes", info.Size(), s.options.VideoMaxSize)
+	}
 
-	return photos, err
+	if s.options.VideoMaxDuration > 0 {
+		duration, ok, err := videoDuration(path)
+		if err != nil {
+			return errors.Wrapf(err, "can't read video duration %q", path)
+		}
+		if !ok {
+			log.Printf("Can't determine duration of %q, duration limit is not checked", path)
+			return nil
+		}
+		if duration > s.options.VideoMaxDuration {
+			return errors.Errorf("video duration %s exceeds limit %s", duration, s.options.VideoMaxDuration)
+		}
+	}
+
+	return nil
 }
 
 // ParsePath парсит путь к файлу относительно базовой директории
@@ -63,3 +257,29 @@ func (s *Service) ParsePath(path string) (relativeDirname, fileName string) {
 	fileName = filepath.Base(relPath)
 	return
 }
+
+// GetPhotoInfo возвращает сведения о файле для шаблонов. Метаданные заполняет MetadataReader
+func (s *Service) GetPhotoInfo(path string) (flickruploader.PhotoInfo, error) {
+	relPath, err := filepath.Rel(s.path, path)
+	if err != nil {
+		return flickruploader.PhotoInfo{}, errors.Wrapf(err, "can't get relative path of %q", path)
+	}
+	mediaType, err := s.GetMediaType(path)
+	if err != nil {
+		return flickruploader.PhotoInfo{}, errors.Wrapf(err, "can't get media type of %q", path)
+	}
+
+	info := flickruploader.PhotoInfo{
+		Path:      path,
+		RelPath:   relPath,
+		FileName:  filepath.Base(path),
+		Ext:       strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."),
+		MediaType: mediaType,
+	}
+	info.Name = strings.TrimSuffix(info.FileName, filepath.Ext(info.FileName))
+	if dir := filepath.Dir(relPath); dir != "." {
+		info.Dir = filepath.ToSlash(dir)
+		info.DirParts = strings.Split(info.Dir, "/")
+	}
+	return info, nil
+}
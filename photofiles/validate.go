@@ -0,0 +1,74 @@
+package photofiles
+
+import (
+	"image"
+	_ "image/gif"  // gif decoder
+	_ "image/jpeg" // jpeg decoder
+	_ "image/png"  // png decoder
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Режимы проверки изображений перед загрузкой
+const (
+	// ValidateOff не проверять
+	ValidateOff = ""
+	// ValidateHeader декодировать заголовок и размеры изображения
+	ValidateHeader = "header"
+	// ValidateFull декодировать изображение целиком
+	ValidateFull = "full"
+)
+
+// Validate проверяет что изображение не повреждено. Файл с расширением JPEG, PNG или GIF, содержимое которого
+// не изображение, считается повреждённым. Видео и TIFF, для которого нет декодера в стандартной библиотеке,
+// не проверяются
+func (s *Service) Validate(path string) error {
+	if s.options.Validate == ValidateOff {
+		return nil
+	}
+
+	head, err := readHead(path)
+	if err != nil {
+		return errors.Wrap(err, "can't read file")
+	}
+	switch imageFormat(head) {
+	case "jpeg", "png", "gif":
+	case "":
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jpg", ".jpeg", ".png", ".gif":
+			return errors.New("content is not an image")
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "can't open file")
+	}
+	defer file.Close()
+
+	if s.options.Validate == ValidateFull {
+		img, _, err := image.Decode(file)
+		if err != nil {
+			return errors.Wrap(err, "can't decode image")
+		}
+		if bounds := img.Bounds(); bounds.Empty() {
+			return errors.Errorf("image has empty bounds %v", bounds)
+		}
+		return nil
+	}
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return errors.Wrap(err, "can't decode image header")
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return errors.Errorf("invalid image dimensions %dx%d", config.Width, config.Height)
+	}
+	return nil
+}
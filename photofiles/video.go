@@ -0,0 +1,91 @@
+package photofiles
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// videoDuration возвращает длительность видео в контейнере ISO BMFF (MP4, MOV, 3GP).
+// ok=false если формат не поддерживается и длительность определить нельзя
+func videoDuration(path string) (duration time.Duration, ok bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+
+	moov, found, err := findBox(file, 0, info.Size(), "moov")
+	if err != nil || !found {
+		return 0, false, err
+	}
+	mvhd, found, err := findBox(file, moov.dataOffset, moov.end, "mvhd")
+	if err != nil || !found {
+		return 0, false, err
+	}
+
+	header := make([]byte, 32)
+	if _, err := file.ReadAt(header, mvhd.dataOffset); err != nil && err != io.EOF {
+		return 0, false, errors.Wrap(err, "can't read mvhd")
+	}
+
+	var timescale, units uint64
+	if header[0] == 1 {
+		// version 1: creation(8) modification(8) timescale(4) duration(8)
+		timescale = uint64(binary.BigEndian.Uint32(header[20:24]))
+		units = binary.BigEndian.Uint64(header[24:32])
+	} else {
+		// version 0: creation(4) modification(4) timescale(4) duration(4)
+		timescale = uint64(binary.BigEndian.Uint32(header[12:16]))
+		units = uint64(binary.BigEndian.Uint32(header[16:20]))
+	}
+	if timescale == 0 {
+		return 0, false, nil
+	}
+
+	return time.Duration(units) * time.Second / time.Duration(timescale), true, nil
+}
+
+type box struct {
+	dataOffset int64
+	end        int64
+}
+
+// findBox ищет атом boxType среди атомов одного уровня в диапазоне [start, end)
+func findBox(r io.ReaderAt, start, end int64, boxType string) (box, bool, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return box{}, false, errors.Wrapf(err, "can't read box header at %d", offset)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0: // до конца файла
+			size = end - offset
+		case 1: // 64-битный размер
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return box{}, false, errors.Wrapf(err, "can't read box size at %d", offset)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return box{}, false, errors.Errorf("invalid box size %d at %d", size, offset)
+		}
+
+		if string(header[4:8]) == boxType {
+			return box{dataOffset: offset + headerSize, end: offset + size}, true, nil
+		}
+		offset += size
+	}
+	return box{}, false, nil
+}
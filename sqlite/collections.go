@@ -0,0 +1,85 @@
+package sqlite
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// collectionsInit creates 'collections' table. A collection mirrors a directory above album directories
+func (s *Service) collectionsInit() error {
+	log.Println("Initing collections table")
+
+	_, err := s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS collections (
+			id text not null primary key,
+			path text not null,
+			parent_id text,
+			title text
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table collections")
+	}
+
+	_, err = s.connection.Exec("CREATE UNIQUE INDEX IF NOT EXISTS collectionpath ON collections (path)")
+	if err != nil {
+		return errors.Wrap(err, "can't create index ON collections (path)")
+	}
+
+	return nil
+}
+
+// CollectionsGetAll returns all collections. Key is collection path
+func (s *Service) CollectionsGetAll() (map[string]flickruploader.Collection, error) {
+	res := map[string]flickruploader.Collection{}
+
+	rows, err := s.connection.Query("SELECT id, path, parent_id, title FROM collections")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select collections")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var collection flickruploader.Collection
+		var parentID, title sql.NullString
+		if err := rows.Scan(&collection.ID, &collection.Path, &parentID, &title); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		collection.ParentID = parentID.String
+		collection.Title = title.String
+		res[collection.Path] = collection
+	}
+	return res, rows.Err()
+}
+
+// CollectionsInsert inserts a new collection
+func (s *Service) CollectionsInsert(collection flickruploader.Collection) error {
+	_, err := s.connection.Exec(
+		"INSERT INTO collections(id, path, parent_id, title) VALUES(?, ?, ?, ?)",
+		collection.ID,
+		collection.Path,
+		collection.ParentID,
+		collection.Title,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Can't insert collection '%s'", collection.Path)
+	}
+	return nil
+}
+
+// CollectionsDelete deletes a collection and removes its sets from it
+func (s *Service) CollectionsDelete(id string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE sets SET collection_id=NULL WHERE collection_id=?", id)
+		if err != nil {
+			return errors.Wrapf(err, "Can't remove sets from collection %s", id)
+		}
+		_, err = tx.Exec("DELETE FROM collections WHERE id=?", id)
+		if err != nil {
+			return errors.Wrapf(err, "Can't delete collection %s", id)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"log"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// failuresInit creates 'failures' table, the queue of files which failed to upload
+func (s *Service) failuresInit() error {
+	log.Println("Initing failures table")
+
+	// size and mod_time identify the file version which failed
+	_, err := s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS failures (
+			path text not null primary key,
+			attempts integer not null,
+			last_error text not null,
+			next_retry_at integer not null,
+			poisoned integer not null default 0,
+			size integer not null,
+			mod_time integer not null
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table failures")
+	}
+
+	return nil
+}
+
+// FailuresSave inserts or updates a failure
+func (s *Service) FailuresSave(entry flickruploader.FailureEntry) error {
+	_, err := s.connection.Exec(
+		`INSERT OR REPLACE INTO failures(path, attempts, last_error, next_retry_at, poisoned, size, mod_time)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		entry.Path,
+		entry.Attempts,
+		entry.LastError,
+		entry.NextRetry.Unix(),
+		entry.Poisoned,
+		entry.Size,
+		entry.ModTime.Unix(),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Can't save failure of %s", entry.Path)
+	}
+	return nil
+}
+
+// FailuresGetAll returns all failures. Key is file path
+func (s *Service) FailuresGetAll() (map[string]flickruploader.FailureEntry, error) {
+	res := map[string]flickruploader.FailureEntry{}
+
+	rows, err := s.connection.Query("SELECT path, attempts, last_error, next_retry_at, poisoned, size, mod_time FROM failures")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select failures")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry flickruploader.FailureEntry
+		var nextRetry, modTime int64
+		err := rows.Scan(&entry.Path, &entry.Attempts, &entry.LastError, &nextRetry, &entry.Poisoned, &entry.Size, &modTime)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		entry.NextRetry = time.Unix(nextRetry, 0)
+		entry.ModTime = time.Unix(modTime, 0)
+		res[entry.Path] = entry
+	}
+	return res, rows.Err()
+}
+
+// FailuresDelete deletes a failure
+func (s *Service) FailuresDelete(path string) error {
+	_, err := s.connection.Exec("DELETE FROM failures WHERE path=?", path)
+	if err != nil {
+		return errors.Wrapf(err, "Can't delete failure of %s", path)
+	}
+	return nil
+}
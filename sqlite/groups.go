@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"log"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// groupsInit creates 'group_photos' table. A photo is submitted to a group pool once, the state records the result
+func (s *Service) groupsInit() error {
+	log.Println("Initing group photos table")
+
+	_, err := s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS group_photos (
+			group_id text not null,
+			photo_id text not null,
+			state text not null,
+			reason text,
+			queued_at integer not null,
+			PRIMARY KEY (group_id, photo_id)
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table group_photos")
+	}
+
+	_, err = s.connection.Exec("CREATE INDEX IF NOT EXISTS groupphotoindex ON group_photos (photo_id)")
+	if err != nil {
+		return errors.Wrap(err, "can't create index ON group_photos (photo_id)")
+	}
+
+	return nil
+}
+
+// GroupsQueue queues the photo for the group pool. Photos which were queued before are not queued again
+func (s *Service) GroupsQueue(groupID, photoID string) error {
+	_, err := s.connection.Exec(
+		"INSERT OR IGNORE INTO group_photos(group_id, photo_id, state, queued_at) VALUES(?, ?, ?, ?)",
+		groupID,
+		photoID,
+		string(flickruploader.GroupQueued),
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Can't queue photo %s for group %s", photoID, groupID)
+	}
+	return nil
+}
+
+// GroupsGetQueued returns photos waiting to be submitted in queue order. Key is group ID
+func (s *Service) GroupsGetQueued() (map[string][]string, error) {
+	res := map[string][]string{}
+
+	rows, err := s.connection.Query(
+		"SELECT group_id, photo_id FROM group_photos WHERE state=? ORDER BY queued_at, rowid",
+		string(flickruploader.GroupQueued),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select queued group photos")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var groupID, photoID string
+		if err := rows.Scan(&groupID, &photoID); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[groupID] = append(res[groupID], photoID)
+	}
+	return res, rows.Err()
+}
+
+// GroupsSetState records the result of submitting the photo to the group pool
+func (s *Service) GroupsSetState(groupID, photoID string, state flickruploader.GroupState, reason string) error {
+	_, err := s.connection.Exec(
+		"UPDATE group_photos SET state=?, reason=? WHERE group_id=? AND photo_id=?",
+		string(state),
+		reason,
+		groupID,
+		photoID,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set state of photo %s in group %s", photoID, groupID)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package sqlite
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// journalInit creates 'journal' table. Every upload is journaled so that it can be finished after a crash
+func (s *Service) journalInit() error {
+	log.Println("Initing journal table")
+
+	_, err := s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS journal (
+			path text not null primary key,
+			state text not null,
+			photo_id text,
+			raw_path text,
+			video integer not null default 0,
+			set_name text not null,
+			set_id text,
+			started_at integer not null
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table journal")
+	}
+
+	err = s.addColumn("journal", "title", "text")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// JournalStart journals an upload as pending. Must be called before the upload API call
+func (s *Service) JournalStart(entry flickruploader.JournalEntry) error {
+	_, err := s.connection.Exec(
+		`INSERT OR REPLACE INTO journal(path, state, raw_path, video, set_name, title, started_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		entry.Path,
+		string(flickruploader.UploadPending),
+		entry.RawPath,
+		entry.Video,
+		entry.SetName,
+		entry.Title,
+		entry.StartedAt.Unix(),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Can't journal upload of %s", entry.Path)
+	}
+	return nil
+}
+
+// JournalUploaded records the uploaded photo in 'photos' and moves the journal entry to 'uploaded' state.
+// A photo ID or path which is already in 'photos' is an error
+func (s *Service) JournalUploaded(path, photoID string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		var rawPath sql.NullString
+		var video bool
+		err := tx.QueryRow("SELECT raw_path, video FROM journal WHERE path=?", path).Scan(&rawPath, &video)
+		if err != nil {
+			return errors.Wrapf(err, "Can't get journal entry of %s", path)
+		}
+
+		var videoStatus sql.NullString
+		if video {
+			videoStatus = sql.NullString{String: string(flickruploader.VideoPending), Valid: true}
+		}
+		_, err = tx.Exec(
+			"INSERT INTO photos(path, id, raw_path, video_status) VALUES(?, ?, ?, ?)",
+			path,
+			photoID,
+			rawPath,
+			videoStatus,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Can't insert photo path:%s", path)
+		}
+
+		_, err = tx.Exec(
+			"UPDATE journal SET state=?, photo_id=? WHERE path=?",
+			string(flickruploader.UploadUploaded),
+			photoID,
+			path,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Can't update journal entry of %s", path)
+		}
+		return nil
+	})
+}
+
+// JournalInSet records set membership of the photo and finishes the journal entry. Empty set ID means no set.
+// If newSet is true the set itself is inserted as well. If pending is true the photo is not added to the set
+// on Flickr yet, see PhotosGetPendingSets
+func (s *Service) JournalInSet(path, photoID string, set flickruploader.Photoset, newSet, pending bool) error {
+	setID := set.ID
+	return s.inTx(func(tx *sql.Tx) error {
+		if newSet {
+			_, err := tx.Exec(
+				"INSERT INTO sets(id, name, album, part) VALUES(?, ?, ?, ?)",
+				set.ID,
+				set.Name,
+				set.Album,
+				set.Part,
+			)
+			if err != nil {
+				return errors.Wrapf(err, "Can't insert set '%s'", set.Name)
+			}
+		}
+
+		_, err := tx.Exec("UPDATE photos SET set_id=?, set_pending=? WHERE id=?", setID, pending, photoID)
+		if err != nil {
+			return errors.Wrapf(err, "Can't add photo %s to set %s", photoID, setID)
+		}
+
+		_, err = tx.Exec(
+			"UPDATE journal SET state=?, set_id=? WHERE path=?",
+			string(flickruploader.UploadInSet),
+			setID,
+			path,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Can't update journal entry of %s", path)
+		}
+		return nil
+	})
+}
+
+// JournalGetUnfinished returns journal entries which are not in 'in_set' state
+func (s *Service) JournalGetUnfinished() ([]flickruploader.JournalEntry, error) {
+	rows, err := s.connection.Query(
+		`SELECT path, state, photo_id, raw_path, video, set_name, title, started_at
+		FROM journal WHERE state != ? ORDER BY path`,
+		string(flickruploader.UploadInSet),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select journal")
+	}
+	defer rows.Close()
+
+	var entries []flickruploader.JournalEntry
+	for rows.Next() {
+		var entry flickruploader.JournalEntry
+		var state string
+		var photoID, rawPath, title sql.NullString
+		var startedAt int64
+		err := rows.Scan(&entry.Path, &state, &photoID, &rawPath, &entry.Video, &entry.SetName, &title, &startedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		entry.State = flickruploader.UploadState(state)
+		entry.PhotoID = photoID.String
+		entry.RawPath = rawPath.String
+		entry.Title = title.String
+		entry.StartedAt = time.Unix(startedAt, 0)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// JournalDelete deletes a journal entry
+func (s *Service) JournalDelete(path string) error {
+	_, err := s.connection.Exec("DELETE FROM journal WHERE path=?", path)
+	if err != nil {
+		return errors.Wrapf(err, "Can't delete journal entry of %s", path)
+	}
+	return nil
+}
+
+// JournalPurgeFinished deletes finished ('in_set') journal entries
+func (s *Service) JournalPurgeFinished() error {
+	_, err := s.connection.Exec("DELETE FROM journal WHERE state=?", string(flickruploader.UploadInSet))
+	if err != nil {
+		return errors.Wrap(err, "Can't purge journal")
+	}
+	return nil
+}
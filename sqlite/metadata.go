@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// metadataInit creates 'file_metadata' table which caches content hash and parsed metadata of files.
+// An entry is valid while the file keeps its size and modification time
+func (s *Service) metadataInit() error {
+	log.Println("Initing metadata table")
+
+	// version is the parser version, rows with an older version are parsed again
+	_, err := s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS file_metadata (
+			path text not null primary key,
+			size integer not null,
+			mtime integer not null,
+			version integer not null,
+			hash text not null,
+			data text not null
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table file_metadata")
+	}
+
+	// the old cache was keyed by content hash, which needed a full read of the file for every lookup
+	_, err = s.connection.Exec("DROP TABLE IF EXISTS metadata")
+	if err != nil {
+		return errors.Wrap(err, "can't drop table metadata")
+	}
+
+	return nil
+}
+
+// MetadataGet returns cached metadata with content hash. ok is false if there is no entry for this version
+// or the file has changed since it was cached
+func (s *Service) MetadataGet(path string, size, mtime int64, version int) (meta flickruploader.Metadata, ok bool, err error) {
+	var hash, data string
+	err = s.connection.QueryRow(
+		"SELECT hash, data FROM file_metadata WHERE path=? AND size=? AND mtime=? AND version=?",
+		path,
+		size,
+		mtime,
+		version,
+	).Scan(&hash, &data)
+	if err == sql.ErrNoRows {
+		return flickruploader.Metadata{}, false, nil
+	}
+	if err != nil {
+		return flickruploader.Metadata{}, false, errors.Wrapf(err, "can't select metadata of %s", path)
+	}
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return flickruploader.Metadata{}, false, errors.Wrapf(err, "can't decode metadata of %s", path)
+	}
+	meta.Hash = hash
+	return meta, true, nil
+}
+
+// MetadataSave caches content hash and metadata of a file with its size and modification time
+func (s *Service) MetadataSave(path string, size, mtime int64, version int, meta flickruploader.Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Wrapf(err, "can't encode metadata of %s", path)
+	}
+	_, err = s.connection.Exec(
+		"INSERT OR REPLACE INTO file_metadata(path, size, mtime, version, hash, data) VALUES(?, ?, ?, ?, ?, ?)",
+		path,
+		size,
+		mtime,
+		version,
+		meta.Hash,
+		string(data),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "can't save metadata of %s", path)
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 package sqlite
 
 import (
+	"database/sql"
 	"log"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
 
 	"github.com/pkg/errors"
 )
@@ -22,6 +26,66 @@ func (s *Service) photosInit() error {
 		return errors.Wrap(err, "can't create table photos")
 	}
 
+	// video_status is NULL for photos
+	err = s.addColumn("photos", "video_status", "text")
+	if err != nil {
+		return err
+	}
+
+	// raw_path is the RAW file the photo was made from or paired with
+	err = s.addColumn("photos", "raw_path", "text")
+	if err != nil {
+		return err
+	}
+
+	// privacy is the serialized flickruploader.Privacy applied to the photo
+	err = s.addColumn("photos", "privacy", "text")
+	if err != nil {
+		return err
+	}
+
+	// geo is the serialized flickruploader.Geo applied to the photo
+	err = s.addColumn("photos", "geo", "text")
+	if err != nil {
+		return err
+	}
+
+	// hash is SHA1 of the file content, it identifies photos moved to another path
+	err = s.addColumn("photos", "hash", "text")
+	if err != nil {
+		return err
+	}
+
+	// set_pending is 1 while the photo is not added to set_id on Flickr yet
+	err = s.addColumn("photos", "set_pending", "integer not null default 0")
+	if err != nil {
+		return err
+	}
+
+	// remove_from is the set the photo is still in on Flickr after it was moved to another part of a split album
+	err = s.addColumn("photos", "remove_from", "text")
+	if err != nil {
+		return err
+	}
+
+	// smart_key identifies smart album rules the photo was checked against, see smart_albums table
+	err = s.addColumn("photos", "smart_key", "text")
+	if err != nil {
+		return err
+	}
+
+	// taken is the date taken in the local time zone, empty if unknown. NULL if it is not recorded yet
+	err = s.addColumn("photos", "taken", "text")
+	if err != nil {
+		return err
+	}
+
+	// rating is the XMP rating of the photo
+	err = s.addColumn("photos", "rating", "integer not null default 0")
+	if err != nil {
+		return err
+	}
+
 	_, err = s.connection.Exec("CREATE UNIQUE INDEX IF NOT EXISTS fileindex ON photos (path)")
 	if err != nil {
 		return errors.Wrap(err, "can't create index (path) on 'photos' table")
@@ -56,45 +120,325 @@ func (s *Service) PhotosGetAll() (map[string]string, error) {
 	return res, nil
 }
 
-// PhotosInsert inserts new photo to DB
-func (s *Service) PhotosInsert(path string, id string) error {
-	stmt, err := s.connection.Prepare("INSERT INTO photos(path, id) VALUES(?, ?)")
-	// todo ? defer stmt.close ??
+// PhotosGetRawPaths returns RAW files of photos which have them. Key is photo path
+func (s *Service) PhotosGetRawPaths() (map[string]string, error) {
+	res := map[string]string{}
+
+	rows, err := s.connection.Query("SELECT path, raw_path FROM photos WHERE raw_path IS NOT NULL AND raw_path != ''")
 	if err != nil {
-		return errors.Wrap(err, "Can't prepare")
+		return nil, errors.Wrap(err, "can't select raw paths")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path, rawPath string
+		if err := rows.Scan(&path, &rawPath); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[path] = rawPath
 	}
-	_, err = stmt.Exec(path, id)
+	return res, rows.Err()
+}
+
+// PhotosSetPrivacy records privacy settings applied to the photo
+func (s *Service) PhotosSetPrivacy(id, privacy string) error {
+	_, err := s.connection.Exec("UPDATE photos SET privacy=? WHERE id=?", privacy, id)
 	if err != nil {
-		return errors.Wrapf(err, "Can't insert photo path:%s error:%s", path, err)
+		return errors.Wrapf(err, "Can't set privacy for photo %s", id)
 	}
 	return nil
 }
 
-// PhotosDelete deletes a photo from DB
-func (s *Service) PhotosDelete(id string) error {
-	stmt, err := s.connection.Prepare("DELETE FROM photos WHERE id=?")
+// PhotosGetPrivacy returns recorded privacy settings of all photos. Key is photo ID, empty value if unknown
+func (s *Service) PhotosGetPrivacy() (map[string]string, error) {
+	res := map[string]string{}
+
+	rows, err := s.connection.Query("SELECT id, privacy FROM photos")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select privacy")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var privacy sql.NullString
+		if err := rows.Scan(&id, &privacy); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[id] = privacy.String
+	}
+	return res, rows.Err()
+}
+
+// PhotosSetGeo records location and geo permissions applied to the photo
+func (s *Service) PhotosSetGeo(id, geo string) error {
+	_, err := s.connection.Exec("UPDATE photos SET geo=? WHERE id=?", geo, id)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set geo for photo %s", id)
+	}
+	return nil
+}
+
+// PhotosGetGeo returns recorded locations of all photos. Key is photo ID, empty value if unknown
+func (s *Service) PhotosGetGeo() (map[string]string, error) {
+	res := map[string]string{}
+
+	rows, err := s.connection.Query("SELECT id, geo FROM photos")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select geo")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var geo sql.NullString
+		if err := rows.Scan(&id, &geo); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[id] = geo.String
+	}
+	return res, rows.Err()
+}
+
+// PhotosSetHash records content hash of the photo file
+func (s *Service) PhotosSetHash(id, hash string) error {
+	_, err := s.connection.Exec("UPDATE photos SET hash=? WHERE id=?", hash, id)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set hash for photo %s", id)
+	}
+	return nil
+}
+
+// PhotosGetHashes returns content hashes of all photos. Key is photo ID, empty value if unknown
+func (s *Service) PhotosGetHashes() (map[string]string, error) {
+	res := map[string]string{}
+
+	rows, err := s.connection.Query("SELECT id, hash FROM photos")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select hashes")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var hash sql.NullString
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[id] = hash.String
+	}
+	return res, rows.Err()
+}
+
+// takenLayout is the format of the 'taken' column
+const takenLayout = "2006-01-02 15:04:05"
+
+// PhotosSetSortKey records the date taken and the rating of the photo
+func (s *Service) PhotosSetSortKey(id string, key flickruploader.SortKey) error {
+	taken := ""
+	if !key.DateTaken.IsZero() {
+		taken = key.DateTaken.Format(takenLayout)
+	}
+	_, err := s.connection.Exec("UPDATE photos SET taken=?, rating=? WHERE id=?", taken, key.Rating, id)
 	if err != nil {
-		return errors.Wrap(err, "Can't prepare")
+		return errors.Wrapf(err, "Can't set sort key for photo %s", id)
+	}
+	return nil
+}
+
+// PhotosGetSortKeys returns dates taken and ratings of photos. Key is photo ID, photos without a recorded
+// sort key are omitted
+func (s *Service) PhotosGetSortKeys() (map[string]flickruploader.SortKey, error) {
+	res := map[string]flickruploader.SortKey{}
+
+	rows, err := s.connection.Query("SELECT id, taken, rating FROM photos WHERE taken IS NOT NULL")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select sort keys")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, taken string
+		var key flickruploader.SortKey
+		if err := rows.Scan(&id, &taken, &key.Rating); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		if taken != "" {
+			key.DateTaken, err = time.ParseInLocation(takenLayout, taken, time.Local)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid date taken of photo %s", id)
+			}
+		}
+		res[id] = key
+	}
+	return res, rows.Err()
+}
+
+// PhotosGetSetIDs returns sets of photos which are in a set. Key is photo ID
+func (s *Service) PhotosGetSetIDs() (map[string]string, error) {
+	res := map[string]string{}
+
+	rows, err := s.connection.Query("SELECT id, set_id FROM photos WHERE set_id IS NOT NULL AND set_id != ''")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select set ids")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, setID string
+		if err := rows.Scan(&id, &setID); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[id] = setID
 	}
+	return res, rows.Err()
+}
 
-	_, err = stmt.Exec(id)
+// PhotosMove records a new path of the photo file moved or renamed locally
+func (s *Service) PhotosMove(id, path, rawPath string) error {
+	var raw sql.NullString
+	if rawPath != "" {
+		raw = sql.NullString{String: rawPath, Valid: true}
+	}
+	// smart album rules and the date taken may depend on the path, they are checked again
+	_, err := s.connection.Exec("UPDATE photos SET path=?, raw_path=?, smart_key=NULL, taken=NULL WHERE id=?", path, raw, id)
 	if err != nil {
-		return errors.Wrapf(err, "Can't delete photo. id=%s", id)
+		return errors.Wrapf(err, "Can't move photo %s to %s", id, path)
 	}
 	return nil
 }
 
-// PhotosAddToSet add photo to set
-func (s *Service) PhotosAddToSet(id, setID string) error {
-	stmt, err := s.connection.Prepare("UPDATE photos SET set_id=? WHERE id=?")
+// PhotosGetPendingSets returns photos waiting to be added to their sets on Flickr. Key is set ID
+func (s *Service) PhotosGetPendingSets() (map[string][]string, error) {
+	res := map[string][]string{}
+
+	rows, err := s.connection.Query("SELECT id, set_id FROM photos WHERE set_pending=1 ORDER BY path")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select pending set membership")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, setID string
+		if err := rows.Scan(&id, &setID); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[setID] = append(res[setID], id)
+	}
+	return res, rows.Err()
+}
+
+// PhotosClearPending marks photos as added to the set on Flickr
+func (s *Service) PhotosClearPending(setID string, photoIDs []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, id := range photoIDs {
+			_, err := tx.Exec("UPDATE photos SET set_pending=0 WHERE id=? AND set_id=?", id, setID)
+			if err != nil {
+				return errors.Wrapf(err, "Can't clear pending set of photo %s", id)
+			}
+		}
+		return nil
+	})
+}
+
+// PhotosGetPendingRemovals returns photos which are moved to another set in DB but are still in the old set
+// on Flickr. Key is the old set ID
+func (s *Service) PhotosGetPendingRemovals() (map[string][]string, error) {
+	res := map[string][]string{}
+
+	rows, err := s.connection.Query("SELECT id, remove_from FROM photos WHERE remove_from IS NOT NULL AND remove_from != '' ORDER BY path")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select pending removals")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, setID string
+		if err := rows.Scan(&id, &setID); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[setID] = append(res[setID], id)
+	}
+	return res, rows.Err()
+}
+
+// PhotosClearRemoval marks photos as removed from the old set on Flickr
+func (s *Service) PhotosClearRemoval(setID string, photoIDs []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, id := range photoIDs {
+			_, err := tx.Exec("UPDATE photos SET remove_from=NULL WHERE id=? AND remove_from=?", id, setID)
+			if err != nil {
+				return errors.Wrapf(err, "Can't clear pending removal of photo %s", id)
+			}
+		}
+		return nil
+	})
+}
+
+// PhotosDelete deletes a photo, its smart album membership and group pool records from DB
+func (s *Service) PhotosDelete(id string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM photos WHERE id=?", id)
+		if err != nil {
+			return errors.Wrapf(err, "Can't delete photo. id=%s", id)
+		}
+		_, err = tx.Exec("DELETE FROM smart_album_photos WHERE photo_id=?", id)
+		if err != nil {
+			return errors.Wrapf(err, "Can't delete smart album membership of photo %s", id)
+		}
+		_, err = tx.Exec("DELETE FROM group_photos WHERE photo_id=?", id)
+		if err != nil {
+			return errors.Wrapf(err, "Can't delete group pool records of photo %s", id)
+		}
+		return nil
+	})
+}
+
+// PhotosSetSmartKey records the smart album rules the photo was checked against
+func (s *Service) PhotosSetSmartKey(id, key string) error {
+	_, err := s.connection.Exec("UPDATE photos SET smart_key=? WHERE id=?", key, id)
 	if err != nil {
-		return errors.Wrap(err, "can't prepare update")
+		return errors.Wrapf(err, "Can't set smart key for photo %s", id)
 	}
+	return nil
+}
+
+// PhotosGetSmartKeys returns smart album rules keys of all photos. Key is photo ID, empty value if never checked
+func (s *Service) PhotosGetSmartKeys() (map[string]string, error) {
+	res := map[string]string{}
 
-	_, err = stmt.Exec(setID, id)
+	rows, err := s.connection.Query("SELECT id, smart_key FROM photos")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select smart keys")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var key sql.NullString
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[id] = key.String
+	}
+	return res, rows.Err()
+}
 
+// PhotosSetVideoStatus sets processing status of an uploaded video
+func (s *Service) PhotosSetVideoStatus(id string, status flickruploader.VideoStatus) error {
+	_, err := s.connection.Exec("UPDATE photos SET video_status=? WHERE id=?", string(status), id)
 	if err != nil {
-		return errors.Wrapf(err, "Can't add photo %s to set %s", id, setID)
+		return errors.Wrapf(err, "Can't set video status %s for photo %s", status, id)
 	}
 	return nil
 }
+
+// PhotosGetByVideoStatus returns IDs of videos with given processing status
+func (s *Service) PhotosGetByVideoStatus(status flickruploader.VideoStatus) ([]string, error) {
+	rows, err := s.connection.Query("SELECT id FROM photos WHERE video_status=? ORDER BY path", string(status))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select videos")
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
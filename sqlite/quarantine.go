@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"log"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// quarantineInit creates 'quarantine' table for files which failed validation
+func (s *Service) quarantineInit() error {
+	log.Println("Initing quarantine table")
+
+	// size and mod_time identify the file version which failed validation
+	_, err := s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS quarantine (
+			path text not null primary key,
+			reason text not null,
+			size integer not null,
+			mod_time integer not null,
+			created_at integer not null
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table quarantine")
+	}
+
+	return nil
+}
+
+// QuarantineInsert puts a file into quarantine or updates its entry
+func (s *Service) QuarantineInsert(entry flickruploader.QuarantineEntry) error {
+	_, err := s.connection.Exec(
+		"INSERT OR REPLACE INTO quarantine(path, reason, size, mod_time, created_at) VALUES(?, ?, ?, ?, ?)",
+		entry.Path,
+		entry.Reason,
+		entry.Size,
+		entry.ModTime.Unix(),
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Can't quarantine file %s", entry.Path)
+	}
+	return nil
+}
+
+// QuarantineGetAll returns all quarantined files. Key is file path
+func (s *Service) QuarantineGetAll() (map[string]flickruploader.QuarantineEntry, error) {
+	res := map[string]flickruploader.QuarantineEntry{}
+
+	rows, err := s.connection.Query("SELECT path, reason, size, mod_time FROM quarantine")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select quarantine")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry flickruploader.QuarantineEntry
+		var modTime int64
+		if err := rows.Scan(&entry.Path, &entry.Reason, &entry.Size, &modTime); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		entry.ModTime = time.Unix(modTime, 0)
+		res[entry.Path] = entry
+	}
+	return res, rows.Err()
+}
+
+// QuarantineDelete releases a file from quarantine
+func (s *Service) QuarantineDelete(path string) error {
+	_, err := s.connection.Exec("DELETE FROM quarantine WHERE path=?", path)
+	if err != nil {
+		return errors.Wrapf(err, "Can't delete file %s from quarantine", path)
+	}
+	return nil
+}
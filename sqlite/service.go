@@ -35,5 +35,94 @@ func NewService(dbFile string) (*Service, error) {
 		return nil, errors.Wrap(err, "can't init sets table")
 	}
 
+	err = service.quarantineInit()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init quarantine table")
+	}
+
+	err = service.failuresInit()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init failures table")
+	}
+
+	err = service.journalInit()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init journal table")
+	}
+
+	err = service.metadataInit()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init metadata table")
+	}
+
+	err = service.collectionsInit()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init collections table")
+	}
+
+	err = service.smartAlbumsInit()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init smart albums tables")
+	}
+
+	err = service.groupsInit()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init group photos table")
+	}
+
 	return &service, nil
 }
+
+// addColumn adds a column to an existing table if it is missing. Used to migrate DBs created by older versions
+func (s *Service) addColumn(table, column, definition string) error {
+	rows, err := s.connection.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return errors.Wrapf(err, "can't get columns of %s", table)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, columnType string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return errors.Wrap(err, "can't scan row")
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "can't read columns")
+	}
+	rows.Close()
+
+	log.Printf("Adding column %s.%s", table, column)
+	_, err = s.connection.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		return errors.Wrapf(err, "can't add column %s.%s", table, column)
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction. The transaction is rolled back if fn returns an error
+func (s *Service) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.connection.Begin()
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("Can't rollback transaction: %s", rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"log"
 
+	"github.com/denisov/flickr-uploader-go"
 	"github.com/pkg/errors"
 )
 
@@ -26,32 +27,192 @@ func (s *Service) setsInit() error {
 		return errors.Wrap(err, "can't create index ON sets (name)")
 	}
 
+	// album_hash is hash of the album file which settings were pushed to Flickr
+	err = s.addColumn("sets", "album_hash", "text")
+	if err != nil {
+		return err
+	}
+
+	// album is the name of the album the set is a part of, part is the key of the part, empty if album isn't split
+	err = s.addColumn("sets", "album", "text")
+	if err != nil {
+		return err
+	}
+	err = s.addColumn("sets", "part", "text not null default ''")
+	if err != nil {
+		return err
+	}
+	_, err = s.connection.Exec("UPDATE sets SET album=name WHERE album IS NULL")
+	if err != nil {
+		return errors.Wrap(err, "can't fill album of sets")
+	}
+
+	_, err = s.connection.Exec("CREATE INDEX IF NOT EXISTS album ON sets (album)")
+	if err != nil {
+		return errors.Wrap(err, "can't create index ON sets (album)")
+	}
+
+	// collection_id is the Flickr collection the set is put in
+	err = s.addColumn("sets", "collection_id", "text")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// SetsInsert inserts new set
-func (s *Service) SetsInsert(id, name string) error {
-	stmt, err := s.connection.Prepare("INSERT INTO sets(id, name) VALUES(?, ?)")
+// setsSelect selects sets with the number of their photos
+const setsSelect = `
+	SELECT s.id, s.name, s.album, s.part, s.collection_id, COUNT(p.id)
+	FROM sets s LEFT JOIN photos p ON p.set_id=s.id`
+
+// scanSets reads rows of setsSelect
+func scanSets(rows *sql.Rows) ([]flickruploader.Photoset, error) {
+	defer rows.Close()
+
+	var sets []flickruploader.Photoset
+	for rows.Next() {
+		var set flickruploader.Photoset
+		var name, album, collection sql.NullString
+		if err := rows.Scan(&set.ID, &name, &album, &set.Part, &collection, &set.Size); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		set.Name = name.String
+		set.Album = album.String
+		set.Collection = collection.String
+		sets = append(sets, set)
+	}
+	return sets, rows.Err()
+}
+
+// SetsGetAll returns all sets. Key is set ID
+func (s *Service) SetsGetAll() (map[string]flickruploader.Photoset, error) {
+	rows, err := s.connection.Query(setsSelect + " GROUP BY s.id")
 	if err != nil {
-		return errors.Wrap(err, "can't prepare query")
+		return nil, errors.Wrap(err, "can't select sets")
 	}
-	_, err = stmt.Exec(id, name)
+	sets, err := scanSets(rows)
 	if err != nil {
-		return errors.Wrapf(err, "Can't insert set '%s'", name)
+		return nil, err
 	}
-	return nil
+
+	res := map[string]flickruploader.Photoset{}
+	for _, set := range sets {
+		res[set.ID] = set
+	}
+	return res, nil
+}
+
+// SetsGetParts returns sets of the album ordered by part. Numbered parts are ordered numerically
+func (s *Service) SetsGetParts(album string) ([]flickruploader.Photoset, error) {
+	rows, err := s.connection.Query(setsSelect+" WHERE s.album=? GROUP BY s.id ORDER BY length(s.part), s.part", album)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't select sets of album '%s'", album)
+	}
+	return scanSets(rows)
 }
 
-// SetsGetIDByName returns set id by name
-func (s *Service) SetsGetIDByName(name string) (string, error) {
-	var setID string
-	err := s.connection.QueryRow("SELECT id FROM sets WHERE name=?", name).Scan(&setID)
+// SetsUpdate changes name, album and part of the set
+func (s *Service) SetsUpdate(set flickruploader.Photoset) error {
+	_, err := s.connection.Exec("UPDATE sets SET name=?, album=?, part=? WHERE id=?", set.Name, set.Album, set.Part, set.ID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", nil
+		return errors.Wrapf(err, "Can't update set %s to '%s'", set.ID, set.Name)
+	}
+	return nil
+}
+
+// SetsSplit moves photos from the set fromID to a part of a split album. If newSet is true the set itself is inserted
+// as well. The primary photo is already in the set on Flickr, others are pending, see PhotosGetPendingSets.
+// All photos are still in fromID on Flickr until they are removed from it, see PhotosGetPendingRemovals
+func (s *Service) SetsSplit(set flickruploader.Photoset, newSet bool, primaryID, fromID string, photoIDs []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if newSet {
+			_, err := tx.Exec(
+				"INSERT INTO sets(id, name, album, part) VALUES(?, ?, ?, ?)",
+				set.ID,
+				set.Name,
+				set.Album,
+				set.Part,
+			)
+			if err != nil {
+				return errors.Wrapf(err, "Can't insert set '%s'", set.Name)
+			}
 		}
-		return "", errors.Wrap(err, "can't select")
+		for _, id := range photoIDs {
+			_, err := tx.Exec(
+				"UPDATE photos SET set_id=?, set_pending=?, remove_from=? WHERE id=?",
+				set.ID,
+				id != primaryID,
+				fromID,
+				id,
+			)
+			if err != nil {
+				return errors.Wrapf(err, "Can't move photo %s to set %s", id, set.ID)
+			}
+		}
+		return nil
+	})
+}
+
+// SetsDeleteEmpty deletes sets among ids which have no photos left: Flickr deletes a set together with its last photo.
+// Only sets which lost photos in the current run are passed: DBs of older versions don't record the photo
+// which created a set, so a set without photos in DB may still exist on Flickr. Returns IDs of deleted sets
+func (s *Service) SetsDeleteEmpty(ids []string) ([]string, error) {
+	var deleted []string
+	err := s.inTx(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			var count int
+			err := tx.QueryRow("SELECT COUNT(*) FROM photos WHERE set_id=?", id).Scan(&count)
+			if err != nil {
+				return errors.Wrapf(err, "can't count photos of set %s", id)
+			}
+			if count > 0 {
+				continue
+			}
+			if _, err := tx.Exec("DELETE FROM sets WHERE id=?", id); err != nil {
+				return errors.Wrapf(err, "Can't delete set %s", id)
+			}
+			deleted = append(deleted, id)
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// SetsSetCollection records the collection the set is put in, empty ID if none
+func (s *Service) SetsSetCollection(id, collectionID string) error {
+	_, err := s.connection.Exec("UPDATE sets SET collection_id=? WHERE id=?", collectionID, id)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set collection of set %s", id)
 	}
+	return nil
+}
 
-	return setID, nil
+// SetsSetAlbumHash records hash of the album file applied to the set
+func (s *Service) SetsSetAlbumHash(id, hash string) error {
+	_, err := s.connection.Exec("UPDATE sets SET album_hash=? WHERE id=?", hash, id)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set album hash for set %s", id)
+	}
+	return nil
+}
+
+// SetsGetAlbumHashes returns recorded album file hashes of all sets. Key is set ID, empty value if none
+func (s *Service) SetsGetAlbumHashes() (map[string]string, error) {
+	res := map[string]string{}
+
+	rows, err := s.connection.Query("SELECT id, album_hash FROM sets")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select album hashes")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var hash sql.NullString
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[id] = hash.String
+	}
+	return res, rows.Err()
 }
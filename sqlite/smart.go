@@ -0,0 +1,205 @@
+package sqlite
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// smartAlbumsInit creates 'smart_albums' table and 'smart_album_photos' table which links photos to smart albums.
+// A photo is in one folder set (photos.set_id) and in any number of smart albums
+func (s *Service) smartAlbumsInit() error {
+	log.Println("Initing smart albums tables")
+
+	// set_id is NULL until the set is created on Flickr and after Flickr deletes it with its last photo
+	_, err := s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS smart_albums (
+			name text not null primary key,
+			set_id text
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table smart_albums")
+	}
+
+	// pending is 1 while the photo is not added to the set on Flickr yet
+	_, err = s.connection.Exec(`
+		CREATE TABLE IF NOT EXISTS smart_album_photos (
+			album text not null,
+			photo_id text not null,
+			pending integer not null default 0,
+			PRIMARY KEY (album, photo_id)
+		)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "can't create table smart_album_photos")
+	}
+
+	_, err = s.connection.Exec("CREATE INDEX IF NOT EXISTS smartphotoindex ON smart_album_photos (photo_id)")
+	if err != nil {
+		return errors.Wrap(err, "can't create index ON smart_album_photos (photo_id)")
+	}
+
+	return nil
+}
+
+// SmartAlbumsGetAll returns all smart albums. Key is album name
+func (s *Service) SmartAlbumsGetAll() (map[string]flickruploader.SmartAlbum, error) {
+	res := map[string]flickruploader.SmartAlbum{}
+
+	rows, err := s.connection.Query("SELECT name, set_id FROM smart_albums")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select smart albums")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var album flickruploader.SmartAlbum
+		var setID sql.NullString
+		if err := rows.Scan(&album.Name, &setID); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		album.SetID = setID.String
+		res[album.Name] = album
+	}
+	return res, rows.Err()
+}
+
+// SmartAlbumsCreate records the set created for the smart album with its first photo
+func (s *Service) SmartAlbumsCreate(album flickruploader.SmartAlbum, photoID string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT OR REPLACE INTO smart_albums(name, set_id) VALUES(?, ?)", album.Name, album.SetID)
+		if err != nil {
+			return errors.Wrapf(err, "Can't save smart album '%s'", album.Name)
+		}
+		_, err = tx.Exec(
+			"INSERT OR REPLACE INTO smart_album_photos(album, photo_id, pending) VALUES(?, ?, 0)",
+			album.Name,
+			photoID,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Can't add photo %s to smart album '%s'", photoID, album.Name)
+		}
+		return nil
+	})
+}
+
+// SmartAlbumsAddPhoto records the photo as pending member of the smart album, see SmartAlbumsGetPending
+func (s *Service) SmartAlbumsAddPhoto(name, photoID string) error {
+	_, err := s.connection.Exec(
+		"INSERT OR REPLACE INTO smart_album_photos(album, photo_id, pending) VALUES(?, ?, 1)",
+		name,
+		photoID,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Can't add photo %s to smart album '%s'", photoID, name)
+	}
+	return nil
+}
+
+// SmartAlbumsRemovePhotos removes photos from the smart album
+func (s *Service) SmartAlbumsRemovePhotos(name string, photoIDs []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, id := range photoIDs {
+			_, err := tx.Exec("DELETE FROM smart_album_photos WHERE album=? AND photo_id=?", name, id)
+			if err != nil {
+				return errors.Wrapf(err, "Can't remove photo %s from smart album '%s'", id, name)
+			}
+		}
+		return nil
+	})
+}
+
+// SmartAlbumsGetMembers returns photos of smart albums. Key is album name
+func (s *Service) SmartAlbumsGetMembers() (map[string][]string, error) {
+	res := map[string][]string{}
+
+	rows, err := s.connection.Query("SELECT album, photo_id FROM smart_album_photos")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select smart album photos")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, id string
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[name] = append(res[name], id)
+	}
+	return res, rows.Err()
+}
+
+// SmartAlbumsGetPending returns photos waiting to be added to smart album sets on Flickr. Key is set ID
+func (s *Service) SmartAlbumsGetPending() (map[string][]string, error) {
+	res := map[string][]string{}
+
+	rows, err := s.connection.Query(`
+		SELECT a.set_id, p.photo_id
+		FROM smart_album_photos p JOIN smart_albums a ON a.name=p.album
+		WHERE p.pending=1 AND a.set_id IS NOT NULL
+		ORDER BY p.photo_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select pending smart album membership")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var setID, id string
+		if err := rows.Scan(&setID, &id); err != nil {
+			return nil, errors.Wrap(err, "can't scan row")
+		}
+		res[setID] = append(res[setID], id)
+	}
+	return res, rows.Err()
+}
+
+// SmartAlbumsClearPending marks photos as added to the smart album set on Flickr
+func (s *Service) SmartAlbumsClearPending(setID string, photoIDs []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, id := range photoIDs {
+			_, err := tx.Exec(
+				"UPDATE smart_album_photos SET pending=0 WHERE photo_id=? AND album IN (SELECT name FROM smart_albums WHERE set_id=?)",
+				id,
+				setID,
+			)
+			if err != nil {
+				return errors.Wrapf(err, "Can't clear pending smart album of photo %s", id)
+			}
+		}
+		return nil
+	})
+}
+
+// SmartAlbumsResetEmpty forgets sets of smart albums without photos: Flickr deletes a set together
+// with its last photo. Returns names of such albums
+func (s *Service) SmartAlbumsResetEmpty() ([]string, error) {
+	var names []string
+	err := s.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT name FROM smart_albums
+			WHERE set_id IS NOT NULL AND name NOT IN (SELECT album FROM smart_album_photos)`)
+		if err != nil {
+			return errors.Wrap(err, "can't select empty smart albums")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return errors.Wrap(err, "can't scan row")
+			}
+			names = append(names, name)
+		}
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "can't read empty smart albums")
+		}
+		rows.Close()
+
+		for _, name := range names {
+			if _, err := tx.Exec("UPDATE smart_albums SET set_id=NULL WHERE name=?", name); err != nil {
+				return errors.Wrapf(err, "Can't reset smart album '%s'", name)
+			}
+		}
+		return nil
+	})
+	return names, err
+}
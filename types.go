@@ -1,5 +1,11 @@
 package flickruploader
 
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
 // OauthToken это токен Oauth авторизации
 // TODO перенести в пакет flickr
 type OauthToken struct {
@@ -7,24 +13,406 @@ type OauthToken struct {
 	TokenSecret string `json:"token_secret"`
 }
 
+// MediaType это тип медиафайла, определённый по содержимому
+type MediaType string
+
+// Типы медиафайлов
+const (
+	MediaUnknown MediaType = ""
+	MediaPhoto   MediaType = "photo"
+	MediaVideo   MediaType = "video"
+)
+
+// VideoStatus это статус обработки видео на стороне Flickr
+type VideoStatus string
+
+// Статусы обработки видео
+const (
+	VideoPending VideoStatus = "pending"
+	VideoReady   VideoStatus = "ready"
+	VideoFailed  VideoStatus = "failed"
+)
+
+// QuarantineEntry это файл, не прошедший проверку перед загрузкой.
+// Size и ModTime запоминают версию файла, изменённый файл проверяется заново
+type QuarantineEntry struct {
+	Path    string
+	Reason  string
+	Size    int64
+	ModTime time.Time
+}
+
+// FailureEntry это файл, который не удалось загрузить.
+// После нескольких неудач файл "отравлен" (Poisoned) и пропускается пока не изменится
+type FailureEntry struct {
+	Path      string
+	Attempts  int
+	LastError string
+	NextRetry time.Time
+	Poisoned  bool
+	Size      int64
+	ModTime   time.Time
+}
+
+// UploadState это состояние загрузки в журнале
+type UploadState string
+
+// Состояния загрузки. Переходы pending -> uploaded -> in_set
+const (
+	// UploadPending загрузка начата, ID фото ещё неизвестен
+	UploadPending UploadState = "pending"
+	// UploadUploaded фото загружено и записано в базу, но ещё не добавлено в фотосет
+	UploadUploaded UploadState = "uploaded"
+	// UploadInSet фото добавлено в фотосет, загрузка завершена
+	UploadInSet UploadState = "in_set"
+)
+
+// JournalEntry это запись журнала загрузки, по которой загрузка доводится до конца после падения
+type JournalEntry struct {
+	Path    string
+	State   UploadState
+	PhotoID string
+	RawPath string
+	Video   bool
+	SetName string
+	// Title название фото на Flickr, по нему фото ищется после падения
+	Title     string
+	StartedAt time.Time
+}
+
+// RemotePhoto это фото на Flickr
+type RemotePhoto struct {
+	ID           string
+	Title        string
+	DateTaken    time.Time
+	DateUploaded time.Time
+}
+
+// PhotoInfo это сведения о локальном файле, доступные в шаблонах тегов, названий и описаний
+type PhotoInfo struct {
+	// Path полный путь к файлу
+	Path string
+	// RelPath путь относительно директории с фото
+	RelPath string
+	// Dir директория относительно директории с фото, пустая строка для файлов в корне
+	Dir string
+	// DirParts компоненты Dir
+	DirParts []string
+	// FileName имя файла с расширением
+	FileName string
+	// Name имя файла без расширения
+	Name string
+	// Ext расширение в нижнем регистре без точки
+	Ext       string
+	MediaType MediaType
+	Metadata
+	// DateSource откуда взята дата съёмки, пустая строка если дата неизвестна
+	DateSource DateSource
+	// Geotagged координаты найдены по GPX треку, а не взяты из EXIF
+	Geotagged bool
+}
+
+// DateSource источник даты съёмки
+type DateSource string
+
+// Источники даты съёмки в порядке приоритета
+const (
+	DateSourceExif  DateSource = "exif"
+	DateSourcePath  DateSource = "path"
+	DateSourceMtime DateSource = "mtime"
+)
+
+// Metadata это метаданные файла из EXIF, IPTC и XMP
+type Metadata struct {
+	// Hash SHA1 содержимого файла, кэшируется вместе с метаданными
+	Hash string `json:"-"`
+	// DateTaken дата съёмки из EXIF, нулевое время если её нет
+	DateTaken time.Time
+	// Camera производитель и модель камеры из EXIF
+	Camera string
+	// Lens объектив из EXIF
+	Lens string
+	// Orientation ориентация из EXIF, 0 если её нет
+	Orientation int
+	// HasGPS в EXIF или XMP есть координаты
+	HasGPS    bool
+	Latitude  float64
+	Longitude float64
+	// Altitude высота над уровнем моря в метрах
+	Altitude float64
+	// Rating рейтинг из XMP: 0 без рейтинга, -1 отклонённое фото
+	Rating int
+	// Keywords ключевые слова из IPTC и XMP без повторов
+	Keywords []string
+	// Title название из XMP dc:title или IPTC Object Name
+	Title string
+	// Headline заголовок из XMP photoshop:Headline или IPTC Headline
+	Headline string
+	// Caption описание из XMP dc:description или IPTC Caption
+	Caption string
+}
+
+// Privacy это настройки видимости фото на Flickr
+type Privacy struct {
+	IsPublic bool
+	IsFriend bool
+	IsFamily bool
+	// SafetyLevel 1 - safe, 2 - moderate, 3 - restricted
+	SafetyLevel int
+	// ContentType 1 - photo, 2 - screenshot, 3 - other
+	ContentType int
+	// Hidden скрыть из публичного поиска
+	Hidden bool
+}
+
+// DefaultPrivacy видимость по умолчанию, совпадает с умолчаниями библиотеки flickr
+var DefaultPrivacy = Privacy{SafetyLevel: 1, ContentType: 1, Hidden: true}
+
+// String сериализует настройки для хранения и сравнения
+func (p Privacy) String() string {
+	return fmt.Sprintf(
+		"public=%t friend=%t family=%t safety=%d content=%d hidden=%t",
+		p.IsPublic, p.IsFriend, p.IsFamily, p.SafetyLevel, p.ContentType, p.Hidden,
+	)
+}
+
+// GeoPerms это права на просмотр местоположения фото на Flickr
+type GeoPerms struct {
+	IsPublic  bool
+	IsContact bool
+	IsFriend  bool
+	IsFamily  bool
+}
+
+// Geo это местоположение фото на Flickr. Нулевое значение - местоположение не меняется
+type Geo struct {
+	// Remove удалить местоположение, которое Flickr импортировал из EXIF при загрузке
+	Remove    bool
+	Latitude  float64
+	Longitude float64
+	// Accuracy точность от 1 (мир) до 16 (улица)
+	Accuracy int
+	Perms    GeoPerms
+}
+
+// IsZero проверяет, что местоположение не меняется
+func (g Geo) IsZero() bool {
+	return g == Geo{}
+}
+
+// String сериализует местоположение для хранения и сравнения, пустая строка для нулевого значения
+func (g Geo) String() string {
+	switch {
+	case g.IsZero():
+		return ""
+	case g.Remove:
+		return "removed"
+	}
+	return fmt.Sprintf(
+		"lat=%.6f lon=%.6f accuracy=%d public=%t contact=%t friend=%t family=%t",
+		g.Latitude, g.Longitude, g.Accuracy, g.Perms.IsPublic, g.Perms.IsContact, g.Perms.IsFriend, g.Perms.IsFamily,
+	)
+}
+
+// Album это настройки альбома из файла .album.yml или album.txt в директории с фото
+type Album struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	// Cover имя файла обложки в директории альбома
+	Cover string `yaml:"cover"`
+	// Sort порядок фото в альбоме: date, date_desc, name, name_desc
+	Sort string `yaml:"sort"`
+	// Hash SHA1 содержимого файла, по нему определяются изменения
+	Hash string `yaml:"-"`
+}
+
+// SortKey дата съёмки и рейтинг загруженного фото. Записываются в базу при загрузке, по ним альбомы упорядочиваются
+// и делятся по дням без чтения файлов
+type SortKey struct {
+	// DateTaken дата съёмки, нулевое время если она неизвестна
+	DateTaken time.Time
+	Rating    int
+}
+
+// Photoset это фотосет на Flickr. Альбом, в котором фото больше максимального размера, делится на несколько
+// фотосетов-частей
+type Photoset struct {
+	ID string
+	// Name название фотосета на Flickr
+	Name string
+	// Album название альбома, частью которого является фотосет
+	Album string
+	// Part ключ части: номер "1", "2"... или день съёмки "2019-07-14", пустая строка - альбом не разделён
+	Part string
+	// Size количество фото фотосета в базе
+	Size int
+	// Collection ID коллекции, в которую входит фотосет, пустая строка - вне коллекций
+	Collection string
+}
+
+// Collection это коллекция на Flickr, соответствующая директории верхнего уровня
+type Collection struct {
+	ID string
+	// Path директория относительно директории с фото, компоненты разделены "/"
+	Path string
+	// ParentID коллекция, в которую вложена эта, пустая строка для коллекции верхнего уровня
+	ParentID string
+	Title    string
+}
+
+// SmartAlbum это альбом, в который фото попадают по правилу из конфига, а не по директории
+type SmartAlbum struct {
+	Name string
+	// SetID фотосет альбома на Flickr, пустая строка пока фотосет не создан
+	SetID string
+}
+
+// GroupState это состояние фото в пуле группы Flickr
+type GroupState string
+
+// Состояния фото в пуле группы. Фото в состоянии, отличном от GroupQueued, в группу больше не отправляется
+const (
+	// GroupQueued фото ждёт отправки в пул, в том числе после ограничения группы
+	GroupQueued GroupState = "queued"
+	// GroupAdded фото в пуле
+	GroupAdded GroupState = "added"
+	// GroupModeration фото в очереди модерации группы
+	GroupModeration GroupState = "moderation"
+	// GroupRejected группа не приняла фото
+	GroupRejected GroupState = "rejected"
+)
+
+// UploadMeta это метаданные, с которыми фото загружается на Flickr
+type UploadMeta struct {
+	Title       string
+	Description string
+	Tags        []string
+	Privacy     Privacy
+	// Geo местоположение, которое устанавливается после загрузки
+	Geo Geo
+	// DateTaken дата съёмки, которая устанавливается после загрузки, нулевое время - оставить дату Flickr
+	DateTaken time.Time
+	// DatePosted дата публикации, которая устанавливается после загрузки, нулевое время - дата загрузки
+	DatePosted time.Time
+	// License ID лицензии Flickr, которая устанавливается после загрузки, пустая строка - лицензия по умолчанию
+	License string
+}
+
 type Filemanager interface {
 	GetAllPhotos() ([]string, error)
 	ParsePath(path string) (relativeDirname, fileName string)
+	GetPhotoInfo(path string) (PhotoInfo, error)
+	GetMediaType(path string) (MediaType, error)
+	CheckVideoLimits(path string) error
+	CheckReady(paths []string) map[string]error
+	GetRawPath(path string) string
+	PrepareUpload(path string, stripGPS bool) (uploadPath string, cleanup func(), err error)
+	Exists(path string) bool
+	Stat(path string) (os.FileInfo, error)
+	GetAlbum(dir string) (Album, bool, error)
+	Validate(path string) error
+}
+
+// TrackPoint это точка GPX трека
+type TrackPoint struct {
+	Latitude  float64
+	Longitude float64
+	// Altitude высота над уровнем моря в метрах
+	Altitude float64
+	Time     time.Time
+}
+
+// Geotagger находит координаты фото по трекам
+type Geotagger interface {
+	Locate(path string, taken time.Time) (TrackPoint, bool)
+}
+
+// MetadataReader читает метаданные файлов
+type MetadataReader interface {
+	Read(path string) (Metadata, error)
 }
 
 type DBStorage interface {
 	PhotosGetAll() (map[string]string, error)
-	PhotosInsert(path string, id string) error
+	PhotosGetRawPaths() (map[string]string, error)
 	PhotosDelete(id string) error
 	//PhotosGetEmptySet() ([][]string, error)
-	PhotosAddToSet(id, setID string) error
-	SetsInsert(id, name string) error
-	SetsGetIDByName(name string) (string, error)
+	PhotosSetVideoStatus(id string, status VideoStatus) error
+	PhotosGetByVideoStatus(status VideoStatus) ([]string, error)
+	QuarantineInsert(entry QuarantineEntry) error
+	QuarantineGetAll() (map[string]QuarantineEntry, error)
+	QuarantineDelete(path string) error
+	FailuresSave(entry FailureEntry) error
+	FailuresGetAll() (map[string]FailureEntry, error)
+	FailuresDelete(path string) error
+	JournalStart(entry JournalEntry) error
+	JournalUploaded(path, photoID string) error
+	JournalInSet(path, photoID string, set Photoset, newSet, pending bool) error
+	JournalGetUnfinished() ([]JournalEntry, error)
+	JournalDelete(path string) error
+	JournalPurgeFinished() error
+	PhotosSetPrivacy(id, privacy string) error
+	PhotosGetPrivacy() (map[string]string, error)
+	PhotosSetGeo(id, geo string) error
+	PhotosGetGeo() (map[string]string, error)
+	PhotosSetHash(id, hash string) error
+	PhotosGetHashes() (map[string]string, error)
+	PhotosGetSetIDs() (map[string]string, error)
+	PhotosMove(id, path, rawPath string) error
+	PhotosSetSortKey(id string, key SortKey) error
+	PhotosGetSortKeys() (map[string]SortKey, error)
+	PhotosGetPendingSets() (map[string][]string, error)
+	PhotosClearPending(setID string, photoIDs []string) error
+	PhotosGetPendingRemovals() (map[string][]string, error)
+	PhotosClearRemoval(setID string, photoIDs []string) error
+	SetsGetAll() (map[string]Photoset, error)
+	SetsGetParts(album string) ([]Photoset, error)
+	SetsUpdate(set Photoset) error
+	SetsSplit(set Photoset, newSet bool, primaryID, fromID string, photoIDs []string) error
+	SetsDeleteEmpty(ids []string) ([]string, error)
+	SetsSetCollection(id, collectionID string) error
+	CollectionsGetAll() (map[string]Collection, error)
+	CollectionsInsert(collection Collection) error
+	CollectionsDelete(id string) error
+	PhotosSetSmartKey(id, key string) error
+	PhotosGetSmartKeys() (map[string]string, error)
+	SmartAlbumsGetAll() (map[string]SmartAlbum, error)
+	SmartAlbumsCreate(album SmartAlbum, photoID string) error
+	SmartAlbumsAddPhoto(name, photoID string) error
+	SmartAlbumsRemovePhotos(name string, photoIDs []string) error
+	SmartAlbumsGetMembers() (map[string][]string, error)
+	SmartAlbumsGetPending() (map[string][]string, error)
+	SmartAlbumsClearPending(setID string, photoIDs []string) error
+	SmartAlbumsResetEmpty() ([]string, error)
+	GroupsQueue(groupID, photoID string) error
+	GroupsGetQueued() (map[string][]string, error)
+	GroupsSetState(groupID, photoID string, state GroupState, reason string) error
+	SetsSetAlbumHash(id, hash string) error
+	SetsGetAlbumHashes() (map[string]string, error)
 }
 
 type RemoteStorage interface {
-	UploadPhoto(photoPath string) (string, error)
+	UploadPhoto(photoPath string, meta UploadMeta) (string, error)
 	DeletePhoto(photoID string) error
 	CreatePhotoset(name, photoID string) (string, error)
-	AddPhotoToPhotoset(photoID, photosetID string) error
+	AddPhotosToPhotoset(photosetID string, photoIDs []string) error
+	RemovePhotosFromPhotoset(photosetID string, photoIDs []string) error
+	GetVideoStatus(photoID string) (VideoStatus, error)
+	FindUploadedPhotos(title string, since time.Time) ([]string, error)
+	FindPhotoset(title string) (string, error)
+	ListUploadedPhotos() ([]RemotePhoto, error)
+	SetPrivacy(photoID string, privacy Privacy) error
+	SetGeo(photoID string, geo Geo) error
+	SetDates(photoID string, taken, posted time.Time) error
+	SetLicense(photoID, licenseID string) error
+	RenamePhotoset(photosetID, title string) error
+	EditPhotoset(photosetID, title, description string) error
+	SetPhotosetCover(photosetID, photoID string) error
+	ReorderPhotoset(photosetID string, photoIDs []string) error
+	CollectionsSupported() (bool, error)
+	CreateCollection(title, parentID string) (string, error)
+	EditCollectionSets(collectionID string, photosetIDs []string) error
+	DeleteCollection(collectionID string) error
+	GroupRemaining(groupID string) (int, error)
+	AddPhotoToGroup(photoID, groupID string) (GroupState, error)
 }
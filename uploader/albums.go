@@ -0,0 +1,326 @@
+package uploader
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// Порядок фото в альбоме
+const (
+	SortDate     = "date"
+	SortDateDesc = "date_desc"
+	SortName     = "name"
+	SortNameDesc = "name_desc"
+)
+
+// Выбор обложки альбома, если она не задана в файле альбома
+const (
+	// CoverKeep оставить обложку, которую выбрал Flickr: первое загруженное фото
+	CoverKeep = "keep"
+	// CoverFirst первое фото в порядке альбома
+	CoverFirst = "first"
+	// CoverRating фото с самым высоким рейтингом, при равном рейтинге - первое
+	CoverRating = "rating"
+)
+
+// AlbumOptions это настройки порядка фото и обложки альбомов по умолчанию
+type AlbumOptions struct {
+	// Sort порядок фото: Sort*, пустая строка - порядок загрузки
+	Sort string
+	// Cover выбор обложки: Cover*, пустая строка равносильна CoverKeep
+	Cover string
+	// MaxSize больше стольких фото альбом делится на несколько фотосетов, 0 - без ограничения
+	MaxSize int
+	// Split деление альбома: Split*, пустая строка равносильна SplitNumber
+	Split string
+}
+
+// checkAlbumOptions проверяет настройки альбомов
+func checkAlbumOptions(options AlbumOptions) error {
+	switch options.Sort {
+	case "", SortDate, SortDateDesc, SortName, SortNameDesc:
+	default:
+		return errors.Errorf("unknown album sort order %q", options.Sort)
+	}
+	switch options.Cover {
+	case "", CoverKeep, CoverFirst, CoverRating:
+	default:
+		return errors.Errorf("unknown album cover rule %q", options.Cover)
+	}
+	switch options.Split {
+	case "", SplitNumber, SplitDay:
+	default:
+		return errors.Errorf("unknown album split %q, expected %s or %s", options.Split, SplitNumber, SplitDay)
+	}
+	if options.MaxSize < 0 {
+		return errors.Errorf("album max size must not be negative, got %d", options.MaxSize)
+	}
+	return nil
+}
+
+// touchSet отмечает фотосет, состав которого изменился в этом запуске
+func (s *Service) touchSet(setID string) {
+	if setID != "" {
+		s.touchedSets[setID] = true
+	}
+}
+
+// SyncAlbums применяет к фотосетам настройки альбомов из файлов .album.yml и album.txt (название, описание,
+// обложку и порядок фото) и настройки альбомов по умолчанию. Фотосет получает настройки директории,
+// в которой больше всего его фото. Меняются только фотосеты, у которых в этом запуске изменился состав
+// или файл альбома: так экономятся запросы к API
+func (s *Service) SyncAlbums() error {
+	if s.isStopped() {
+		return nil
+	}
+
+	dbFiles, err := s.dbStorage.PhotosGetAll()
+	if err != nil {
+		return errors.Wrap(err, "can't get all photos from DB")
+	}
+	setIDs, err := s.dbStorage.PhotosGetSetIDs()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets of photos from db storage")
+	}
+	sets, err := s.dbStorage.SetsGetAll()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets from db storage")
+	}
+	applied, err := s.dbStorage.SetsGetAlbumHashes()
+	if err != nil {
+		return errors.Wrap(err, "Can't get album hashes from db storage")
+	}
+	sortKeys, err := s.dbStorage.PhotosGetSortKeys()
+	if err != nil {
+		return errors.Wrap(err, "Can't get sort keys of photos from db storage")
+	}
+
+	members := map[string][]string{}
+	for path, photoID := range dbFiles {
+		if setID := setIDs[photoID]; setID != "" {
+			members[setID] = append(members[setID], path)
+		}
+	}
+	setIDList := make([]string, 0, len(members))
+	for setID := range members {
+		setIDList = append(setIDList, setID)
+	}
+	sort.Strings(setIDList)
+
+	for _, setID := range setIDList {
+		if s.isStopped() {
+			return nil
+		}
+		paths := members[setID]
+		sort.Strings(paths)
+
+		dir := s.albumDir(paths)
+		album, ok, err := s.fileManager.GetAlbum(dir)
+		if err != nil {
+			s.reportError(errors.Wrapf(err, "Can't read album of photoset '%s'", sets[setID].Name))
+			continue
+		}
+		albumChanged := ok && album.Hash != applied[setID]
+		if !albumChanged && !s.touchedSets[setID] {
+			continue
+		}
+
+		if albumChanged {
+			log.Printf("Apply album settings of %q to photoset '%s' (%s)", dir, sets[setID].Name, setID)
+			if err := s.editAlbum(sets[setID], album); err != nil {
+				s.reportError(err)
+				continue
+			}
+		}
+		if err := s.arrangeAlbum(setID, sets[setID].Name, album, paths, dbFiles, sortKeys); err != nil {
+			s.reportError(err)
+			continue
+		}
+		if albumChanged {
+			if err := s.dbStorage.SetsSetAlbumHash(setID, album.Hash); err != nil {
+				return errors.Wrapf(err, "Can't record album hash of photoset %s", setID)
+			}
+		}
+	}
+	return nil
+}
+
+// albumDir возвращает директорию (относительно директории с фото), в которой больше всего фото из paths
+func (s *Service) albumDir(paths []string) string {
+	counts := map[string]int{}
+	var best string
+	for _, path := range paths {
+		dir, _ := s.fileManager.ParsePath(path)
+		if dir == "." {
+			dir = ""
+		}
+		dir = filepath.ToSlash(dir)
+		counts[dir]++
+		if counts[dir] > counts[best] || (counts[dir] == counts[best] && dir < best) {
+			best = dir
+		}
+	}
+	return best
+}
+
+// editAlbum отправляет на Flickr название и описание альбома. Часть разделённого альбома сохраняет ключ части
+// в названии
+func (s *Service) editAlbum(set flickruploader.Photoset, album flickruploader.Album) error {
+	title := set.Name
+	if album.Title != "" {
+		title = partTitle(album.Title, set.Part)
+	}
+	if err := s.remoteStorage.EditPhotoset(set.ID, title, album.Description); err != nil {
+		return errors.Wrapf(err, "Can't edit photoset '%s'", set.Name)
+	}
+	return nil
+}
+
+// arrangeAlbum упорядочивает фото альбома и выбирает обложку. Настройки файла альбома важнее настроек по умолчанию.
+// paths - фото фотосета, dbFiles - ID фото по пути, sortKeys - ключи упорядочивания по ID фото, см. photoSortKeys
+func (s *Service) arrangeAlbum(setID, setName string, album flickruploader.Album, paths []string, dbFiles map[string]string,
+	sortKeys map[string]flickruploader.SortKey) error {
+	order := album.Sort
+	if order == "" {
+		order = s.options.Albums.Sort
+	}
+	rule := s.options.Albums.Cover
+	if rule == "" {
+		rule = CoverKeep
+	}
+	if order == "" && rule == CoverKeep && album.Cover == "" {
+		return nil
+	}
+
+	var keys map[string]flickruploader.SortKey
+	if order == SortDate || order == SortDateDesc || (album.Cover == "" && rule == CoverRating) {
+		var err error
+		keys, err = s.photoSortKeys(paths, dbFiles, sortKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	sorted, err := sortPhotos(paths, order, keys)
+	if err != nil {
+		return errors.Wrapf(err, "Can't sort photoset '%s'", setName)
+	}
+
+	if order != "" {
+		photoIDs := make([]string, len(sorted))
+		for i, path := range sorted {
+			photoIDs[i] = dbFiles[path]
+		}
+		log.Printf("Reorder photoset '%s' (%s) by %s", setName, setID, order)
+		if err := s.remoteStorage.ReorderPhotoset(setID, photoIDs); err != nil {
+			return errors.Wrapf(err, "Can't reorder photoset '%s'", setName)
+		}
+	}
+
+	cover, err := coverPath(sorted, album.Cover, rule, keys)
+	if err != nil {
+		return errors.Wrapf(err, "Can't choose cover of photoset '%s'", setName)
+	}
+	if cover == "" {
+		return nil
+	}
+	log.Printf("Set cover of photoset '%s' (%s): %q", setName, setID, cover)
+	if err := s.remoteStorage.SetPhotosetCover(setID, dbFiles[cover]); err != nil {
+		return errors.Wrapf(err, "Can't set cover of photoset '%s'", setName)
+	}
+	return nil
+}
+
+// coverPath выбирает обложку среди упорядоченных фото: файл coverFile из файла альбома или по правилу rule.
+// keys - ключи упорядочивания по пути, нужны только для выбора по рейтингу. Пустая строка - обложку не менять
+func coverPath(sorted []string, coverFile, rule string, keys map[string]flickruploader.SortKey) (string, error) {
+	if coverFile != "" {
+		for _, path := range sorted {
+			if strings.EqualFold(filepath.Base(path), coverFile) {
+				return path, nil
+			}
+		}
+		return "", errors.Errorf("cover %q is not in the photoset", coverFile)
+	}
+
+	switch rule {
+	case CoverFirst:
+		return sorted[0], nil
+	case CoverRating:
+		best, bestRating := sorted[0], 0
+		for i, path := range sorted {
+			if i == 0 || keys[path].Rating > bestRating {
+				best, bestRating = path, keys[path].Rating
+			}
+		}
+		return best, nil
+	}
+	return "", nil
+}
+
+// sortPhotos упорядочивает фото. keys - ключи упорядочивания по пути, нужны только для порядка по дате.
+// Фото без даты идут после фото с датой. При равных датах, для фото без даты, а также без порядка (order пустой),
+// порядок определяется именем файла
+func sortPhotos(paths []string, order string, keys map[string]flickruploader.SortKey) ([]string, error) {
+	sorted := append([]string(nil), paths...)
+	byName := func(i, j int) bool {
+		return strings.ToLower(filepath.Base(sorted[i])) < strings.ToLower(filepath.Base(sorted[j]))
+	}
+
+	switch order {
+	case "", SortName:
+		sort.SliceStable(sorted, byName)
+	case SortNameDesc:
+		sort.SliceStable(sorted, func(i, j int) bool { return byName(j, i) })
+	case SortDate, SortDateDesc:
+		sort.SliceStable(sorted, byName)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			first, second := keys[sorted[i]].DateTaken, keys[sorted[j]].DateTaken
+			if first.IsZero() || second.IsZero() {
+				return !first.IsZero() && second.IsZero()
+			}
+			if order == SortDateDesc {
+				return first.After(second)
+			}
+			return first.Before(second)
+		})
+	default:
+		return nil, errors.Errorf("unknown sort order %q, expected %s, %s, %s or %s", order, SortDate, SortDateDesc, SortName, SortNameDesc)
+	}
+	return sorted, nil
+}
+
+// sortKey возвращает ключ упорядочивания фото
+func sortKey(info flickruploader.PhotoInfo) flickruploader.SortKey {
+	return flickruploader.SortKey{DateTaken: info.DateTaken, Rating: info.Rating}
+}
+
+// photoSortKeys возвращает ключи упорядочивания фото paths по пути. dbFiles - ID фото по пути, keys - ключи из базы
+// по ID фото. Фото без ключа в базе (загруженные старыми версиями или перемещённые) читаются один раз: их ключи
+// записываются в базу и в keys. Фото, которое не удалось прочитать, считается фото без даты
+func (s *Service) photoSortKeys(paths []string, dbFiles map[string]string, keys map[string]flickruploader.SortKey) (map[string]flickruploader.SortKey, error) {
+	res := make(map[string]flickruploader.SortKey, len(paths))
+	for _, path := range paths {
+		photoID := dbFiles[path]
+		key, ok := keys[photoID]
+		if !ok {
+			photoInfo, err := s.photoInfo(path)
+			if err != nil {
+				log.Printf("Can't get info of %q: %s. Treat the photo as undated", path, err)
+				continue
+			}
+			key = sortKey(photoInfo)
+			if err := s.dbStorage.PhotosSetSortKey(photoID, key); err != nil {
+				return nil, errors.Wrapf(err, "Can't record sort key of %q", path)
+			}
+			keys[photoID] = key
+		}
+		res[path] = key
+	}
+	return res, nil
+}
@@ -0,0 +1,231 @@
+package uploader
+
+import (
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// CollectionOptions это настройки коллекций
+type CollectionOptions struct {
+	// Levels столько верхних уровней директорий становятся вложенными коллекциями, 0 - коллекции не создаются.
+	// Директория альбома коллекцией не становится: альбом "2019/italy" при Levels 1 попадает в коллекцию "2019"
+	Levels int
+}
+
+// ownSetsSuffix добавляется к пути коллекции, в которой есть вложенные коллекции: её фотосеты кладутся во вложенную
+// коллекцию с тем же названием, потому что Flickr не разрешает класть в одну коллекцию и фотосеты и коллекции.
+// Пустого компонента нет в путях директорий, поэтому такой путь не совпадает с директорией
+const ownSetsSuffix = "/"
+
+// collectionPath возвращает путь коллекции для директории альбома dir, пустую строку если альбом вне коллекций
+func (s *Service) collectionPath(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	parts := strings.Split(dir, "/")
+	levels := s.options.Collections.Levels
+	if levels > len(parts)-1 {
+		levels = len(parts) - 1
+	}
+	return strings.Join(parts[:levels], "/")
+}
+
+// SyncCollections раскладывает фотосеты по коллекциям, повторяющим верхние уровни директорий.
+// Коллекция фотосета определяется директорией, в которой больше всего его фото. Фотосеты коллекции, в которой есть
+// вложенные коллекции, кладутся во вложенную коллекцию с тем же названием, см. ownSetsSuffix. Недостающие коллекции
+// создаются, на Flickr меняются только коллекции, состав которых изменился. Коллекции, в которых не осталось
+// ни фотосетов, ни вложенных коллекций, удаляются. Методы изменения коллекций не описаны в Flickr API: если Flickr
+// их не принимает, коллекции не синхронизируются
+func (s *Service) SyncCollections() error {
+	if s.isStopped() || s.options.Collections.Levels == 0 {
+		return nil
+	}
+	supported, err := s.remoteStorage.CollectionsSupported()
+	if err != nil {
+		s.reportError(err)
+		return nil
+	}
+	if !supported {
+		log.Printf("Flickr doesn't accept collection methods, collections are not synced")
+		return nil
+	}
+
+	dbFiles, err := s.dbStorage.PhotosGetAll()
+	if err != nil {
+		return errors.Wrap(err, "can't get all photos from DB")
+	}
+	setIDs, err := s.dbStorage.PhotosGetSetIDs()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets of photos from db storage")
+	}
+	sets, err := s.dbStorage.SetsGetAll()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets from db storage")
+	}
+	collections, err := s.dbStorage.CollectionsGetAll()
+	if err != nil {
+		return errors.Wrap(err, "Can't get collections from db storage")
+	}
+
+	members := map[string][]string{}
+	for path, photoID := range dbFiles {
+		if setID := setIDs[photoID]; setID != "" {
+			members[setID] = append(members[setID], path)
+		}
+	}
+	setIDList := make([]string, 0, len(sets))
+	for setID := range sets {
+		setIDList = append(setIDList, setID)
+	}
+	sort.Strings(setIDList)
+
+	paths := map[string]string{}
+	for _, setID := range setIDList {
+		paths[setID] = s.collectionPath(s.albumDir(members[setID]))
+	}
+	paths = withOwnSets(paths)
+
+	// desired коллекция фотосета, changed коллекции, состав которых изменился
+	desired := map[string]string{}
+	changed := map[string]bool{}
+	for _, setID := range setIDList {
+		if s.isStopped() {
+			return nil
+		}
+		var collectionID string
+		if collectionPath := paths[setID]; collectionPath != "" {
+			collectionID, err = s.ensureCollection(collectionPath, collections)
+			if err != nil {
+				s.reportError(err)
+				desired[setID] = sets[setID].Collection
+				continue
+			}
+		}
+		desired[setID] = collectionID
+		if current := sets[setID].Collection; current != collectionID {
+			changed[current] = true
+			changed[collectionID] = true
+		}
+	}
+	delete(changed, "")
+
+	byPath := make([]flickruploader.Collection, 0, len(collections))
+	for _, collection := range collections {
+		byPath = append(byPath, collection)
+	}
+	// вложенные коллекции обрабатываются раньше родительских, чтобы родительская успела опустеть
+	sort.Slice(byPath, func(i, j int) bool { return byPath[i].Path > byPath[j].Path })
+
+	children := map[string]int{}
+	for _, collection := range byPath {
+		children[collection.ParentID]++
+	}
+	for _, collection := range byPath {
+		if s.isStopped() {
+			return nil
+		}
+		var collectionSets []string
+		for _, setID := range setIDList {
+			if desired[setID] == collection.ID {
+				collectionSets = append(collectionSets, setID)
+			}
+		}
+
+		if len(collectionSets) == 0 && children[collection.ID] == 0 {
+			log.Printf("Collection '%s' (%s) is empty. Delete it", collection.Path, collection.ID)
+			if err := s.remoteStorage.DeleteCollection(collection.ID); err != nil {
+				s.reportError(errors.Wrapf(err, "Can't delete collection '%s'", collection.Path))
+				continue
+			}
+			if err := s.dbStorage.CollectionsDelete(collection.ID); err != nil {
+				return errors.Wrapf(err, "Can't delete collection %s from db storage", collection.ID)
+			}
+			children[collection.ParentID]--
+			continue
+		}
+		if !changed[collection.ID] {
+			continue
+		}
+
+		log.Printf("Put %d photosets in collection '%s' (%s)", len(collectionSets), collection.Path, collection.ID)
+		if err := s.remoteStorage.EditCollectionSets(collection.ID, collectionSets); err != nil {
+			s.reportError(errors.Wrapf(err, "Can't edit collection '%s'", collection.Path))
+			continue
+		}
+		if err := s.recordCollection(sets, desired, collection.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withOwnSets переносит фотосеты из коллекций, в которых есть вложенные коллекции, во вложенную коллекцию с тем же
+// названием, см. ownSetsSuffix. paths - путь коллекции по ID фотосета, пустая строка - вне коллекций
+func withOwnSets(paths map[string]string) map[string]string {
+	parents := map[string]bool{}
+	for _, collectionPath := range paths {
+		for dir := path.Dir(collectionPath); dir != "."; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+	res := make(map[string]string, len(paths))
+	for setID, collectionPath := range paths {
+		if parents[collectionPath] {
+			collectionPath += ownSetsSuffix
+		}
+		res[setID] = collectionPath
+	}
+	return res
+}
+
+// ensureCollection возвращает ID коллекции по пути, создавая её и родительские коллекции при необходимости.
+// collections - коллекции по пути, дополняется созданными
+func (s *Service) ensureCollection(collectionPath string, collections map[string]flickruploader.Collection) (string, error) {
+	if collection, ok := collections[collectionPath]; ok {
+		return collection.ID, nil
+	}
+
+	var parentID string
+	if parentPath := path.Dir(collectionPath); parentPath != "." {
+		var err error
+		parentID, err = s.ensureCollection(parentPath, collections)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	collection := flickruploader.Collection{Path: collectionPath, ParentID: parentID, Title: path.Base(collectionPath)}
+	log.Printf("Collection '%s' doesn't exists. Create it", collectionPath)
+	collectionID, err := s.remoteStorage.CreateCollection(collection.Title, parentID)
+	if err != nil {
+		return "", errors.Wrapf(err, "Can't create collection '%s'", collectionPath)
+	}
+	collection.ID = collectionID
+	if err := s.dbStorage.CollectionsInsert(collection); err != nil {
+		return "", errors.Wrapf(err, "Can't insert collection '%s' to db storage", collectionPath)
+	}
+	collections[collectionPath] = collection
+	return collectionID, nil
+}
+
+// recordCollection записывает в базу состав коллекции collectionID, изменённой на Flickr: фотосеты, которые
+// в неё попали, и фотосеты, которые вышли из неё и не попали ни в одну коллекцию
+func (s *Service) recordCollection(sets map[string]flickruploader.Photoset, desired map[string]string, collectionID string) error {
+	for setID, set := range sets {
+		target, ok := desired[setID]
+		if !ok || target == set.Collection {
+			continue
+		}
+		if target == collectionID || (target == "" && set.Collection == collectionID) {
+			if err := s.dbStorage.SetsSetCollection(setID, target); err != nil {
+				return errors.Wrapf(err, "Can't record collection of photoset %s", setID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package uploader
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// DefaultDatePatterns шаблоны дат в именах файлов и директорий, если в настройках ничего не задано:
+// 2006_12_29, 2006-12-29, 20061229_153000, IMG-20061229-WA0001
+var DefaultDatePatterns = []string{
+	`(?P<year>(?:19|20)\d{2})[-_.]?(?P<month>[01]\d)[-_.]?(?P<day>[0-3]\d)` +
+		`(?:[-_ T]?(?P<hour>[0-2]\d)[-_.:]?(?P<minute>[0-5]\d)[-_.:]?(?P<second>[0-5]\d))?`,
+}
+
+// DateOptions это настройки определения даты съёмки
+type DateOptions struct {
+	// Patterns регулярные выражения с именованными группами year, month, day, hour, minute, second.
+	// Обязательна только year. Применяются к имени файла, затем к пути директории
+	Patterns []string
+	// Mtime использовать дату изменения файла, если других дат нет
+	Mtime bool
+	// Offset поправка к дате съёмки из EXIF: неверный часовой пояс или часы камеры
+	Offset time.Duration
+	// BackdatePosted ставить дату публикации равной дате съёмки
+	BackdatePosted bool
+}
+
+// compileDatePatterns разбирает шаблоны дат
+func compileDatePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = DefaultDatePatterns
+	}
+
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't parse date pattern %q", pattern)
+		}
+		if re.SubexpIndex("year") < 0 {
+			return nil, errors.Errorf("date pattern %q has no (?P<year>...) group", pattern)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// resolveDate заполняет дату съёмки: EXIF с поправкой, затем дата из имени файла или директории, затем дата изменения.
+// precise true, если известно время съёмки: дата из EXIF или дата из пути вместе со временем. Только по такой
+// дате фото можно искать на GPX треке, дата изменения и дата без времени дали бы чужие координаты
+func (s *Service) resolveDate(info *flickruploader.PhotoInfo) (precise bool) {
+	if !info.DateTaken.IsZero() {
+		info.DateTaken = info.DateTaken.Add(s.options.Dates.Offset)
+		info.DateSource = flickruploader.DateSourceExif
+		return true
+	}
+
+	if date, hasTime, ok := s.metaBuilder.pathDate(info.Name, info.Dir); ok {
+		info.DateTaken = date
+		info.DateSource = flickruploader.DateSourcePath
+		return hasTime
+	}
+
+	if s.options.Dates.Mtime {
+		fileInfo, err := s.fileManager.Stat(info.Path)
+		if err != nil {
+			log.Printf("Can't stat %q: %s", info.Path, err)
+			return false
+		}
+		info.DateTaken = fileInfo.ModTime()
+		info.DateSource = flickruploader.DateSourceMtime
+	}
+	return false
+}
+
+// pathDate ищет дату в имени файла, затем в пути директории. В директории выбирается самая вложенная дата.
+// hasTime true, если вместе с датой найдено время
+func (b *metaBuilder) pathDate(name, dir string) (date time.Time, hasTime, ok bool) {
+	for _, text := range []string{name, dir} {
+		for _, re := range b.datePatterns {
+			matches := re.FindAllStringSubmatch(text, -1)
+			for i := len(matches) - 1; i >= 0; i-- {
+				if matched, valid := matchDate(re, matches[i]); valid {
+					hour := re.SubexpIndex("hour")
+					return matched, hour >= 0 && matches[i][hour] != "", true
+				}
+			}
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// matchDate собирает дату из групп совпадения. Несуществующие даты (2006_13_45) отбрасываются
+func matchDate(re *regexp.Regexp, match []string) (time.Time, bool) {
+	group := func(name string, fallback int) int {
+		i := re.SubexpIndex(name)
+		if i < 0 || match[i] == "" {
+			return fallback
+		}
+		value, err := strconv.Atoi(match[i])
+		if err != nil {
+			return -1
+		}
+		return value
+	}
+
+	year, month, day := group("year", -1), group("month", 1), group("day", 1)
+	hour, minute, second := group("hour", 0), group("minute", 0), group("second", 0)
+	date := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day ||
+		date.Hour() != hour || date.Minute() != minute || date.Second() != second {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// dates возвращает даты съёмки и публикации, которые нужно установить на Flickr.
+// Дату съёмки из EXIF без поправки Flickr определяет сам
+func (b *metaBuilder) dates(info flickruploader.PhotoInfo) (taken, posted time.Time) {
+	if info.DateTaken.IsZero() {
+		return time.Time{}, time.Time{}
+	}
+	if info.DateSource != flickruploader.DateSourceExif || b.options.Dates.Offset != 0 {
+		taken = info.DateTaken
+	}
+	if b.options.Dates.BackdatePosted {
+		posted = info.DateTaken
+	}
+	return taken, posted
+}
+
+// applyDates устанавливает даты загруженного фото.
+// Ошибка API не мешает загрузке: фото остаётся с датами, которые определил Flickr
+func (s *Service) applyDates(photoPath, photoID string, meta flickruploader.UploadMeta) {
+	if meta.DateTaken.IsZero() && meta.DatePosted.IsZero() {
+		return
+	}
+
+	log.Printf("Set dates of %q (%s): taken %s, posted %s", photoPath, photoID, meta.DateTaken, meta.DatePosted)
+	if err := s.remoteStorage.SetDates(photoID, meta.DateTaken, meta.DatePosted); err != nil {
+		s.reportError(errors.Wrapf(err, "Can't set dates of %q", photoPath))
+	}
+}
@@ -0,0 +1,99 @@
+package uploader
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// maxRetryDelay ограничивает рост паузы между попытками
+const maxRetryDelay = 7 * 24 * time.Hour
+
+// isWaitingRetry проверяет что файл уже падал и время следующей попытки не наступило, либо файл "отравлен".
+// Изменённый файл начинает попытки заново
+func (s *Service) isWaitingRetry(path string, fileInfo os.FileInfo) bool {
+	failure, ok := s.failures[path]
+	if !ok {
+		return false
+	}
+
+	if failure.Size != fileInfo.Size() || failure.ModTime.Unix() != fileInfo.ModTime().Unix() {
+		log.Printf("Failed file %q has changed. Try it again", path)
+		return false
+	}
+
+	if failure.Poisoned {
+		log.Printf("Skip poisoned file %q. Failed %d times: %s", path, failure.Attempts, failure.LastError)
+		s.report.Failed = append(s.report.Failed, failure)
+		return true
+	}
+	if time.Now().Before(failure.NextRetry) {
+		log.Printf("Skip failed file %q until %s", path, failure.NextRetry.Format(time.RFC3339))
+		s.report.Deferred = append(s.report.Deferred, path)
+		return true
+	}
+	return false
+}
+
+// recordFailure записывает ошибку загрузки файла и назначает время следующей попытки.
+// Первая ошибка файла и ошибка, после которой файл становится "отравленным", считаются новыми, см. Report.HasFailures
+func (s *Service) recordFailure(path string, fileInfo os.FileInfo, uploadErr error) error {
+	failure, ok := s.failures[path]
+	isNew := false
+	if !ok || failure.Size != fileInfo.Size() || failure.ModTime.Unix() != fileInfo.ModTime().Unix() {
+		failure = flickruploader.FailureEntry{
+			Path:    path,
+			Size:    fileInfo.Size(),
+			ModTime: fileInfo.ModTime(),
+		}
+		isNew = true
+	}
+
+	failure.Attempts++
+	failure.LastError = uploadErr.Error()
+	failure.NextRetry = time.Now().Add(s.retryDelay(failure.Attempts))
+	if s.options.MaxAttempts > 0 && failure.Attempts >= s.options.MaxAttempts {
+		log.Printf("File %q failed %d times, mark it as poisoned", path, failure.Attempts)
+		failure.Poisoned = true
+		isNew = true
+	}
+
+	err := s.dbStorage.FailuresSave(failure)
+	if err != nil {
+		return errors.Wrapf(err, "Can't save failure of %q", path)
+	}
+	s.failures[path] = failure
+	s.report.Failed = append(s.report.Failed, failure)
+	if isNew {
+		s.report.NewFailures++
+	}
+	return nil
+}
+
+// clearFailure удаляет файл из очереди ошибок после успешной обработки
+func (s *Service) clearFailure(path string) error {
+	if _, ok := s.failures[path]; !ok {
+		return nil
+	}
+	err := s.dbStorage.FailuresDelete(path)
+	if err != nil {
+		return errors.Wrapf(err, "Can't delete failure of %q", path)
+	}
+	delete(s.failures, path)
+	return nil
+}
+
+// retryDelay пауза перед попыткой номер attempts+1, удваивается с каждой неудачей
+func (s *Service) retryDelay(attempts int) time.Duration {
+	delay := s.options.RetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
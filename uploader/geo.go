@@ -0,0 +1,156 @@
+package uploader
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// GeoMode что делать с местоположением фото на Flickr
+type GeoMode string
+
+const (
+	// GeoKeep не трогать местоположение: Flickr сам импортирует координаты из EXIF, если это включено в аккаунте
+	GeoKeep GeoMode = "keep"
+	// GeoSet установить координаты из метаданных и права на их просмотр
+	GeoSet GeoMode = "set"
+	// GeoRemove удалить местоположение, которое Flickr импортировал из EXIF
+	GeoRemove GeoMode = "remove"
+)
+
+// GeoOptions это настройки местоположения загружаемых фото
+type GeoOptions struct {
+	// Mode пустое значение равносильно GeoKeep
+	Mode GeoMode
+	// Accuracy точность от 1 (мир) до 16 (улица), 0 - по умолчанию Flickr (16)
+	Accuracy int
+	Perms    flickruploader.GeoPerms
+}
+
+// GeoOverride переопределяет часть настроек местоположения, nil поля не меняются
+type GeoOverride struct {
+	Mode      *GeoMode
+	Accuracy  *int
+	IsPublic  *bool
+	IsContact *bool
+	IsFriend  *bool
+	IsFamily  *bool
+}
+
+// Apply возвращает настройки base с переопределёнными полями
+func (o GeoOverride) Apply(base GeoOptions) GeoOptions {
+	if o.Mode != nil {
+		base.Mode = *o.Mode
+	}
+	if o.Accuracy != nil {
+		base.Accuracy = *o.Accuracy
+	}
+	if o.IsPublic != nil {
+		base.Perms.IsPublic = *o.IsPublic
+	}
+	if o.IsContact != nil {
+		base.Perms.IsContact = *o.IsContact
+	}
+	if o.IsFriend != nil {
+		base.Perms.IsFriend = *o.IsFriend
+	}
+	if o.IsFamily != nil {
+		base.Perms.IsFamily = *o.IsFamily
+	}
+	return base
+}
+
+// DirectoryGeo это настройки местоположения для директории (относительно директории с фото) и всех вложенных
+type DirectoryGeo struct {
+	Dir      string
+	Override GeoOverride
+}
+
+// sortedDirectoryGeo сортирует настройки директорий от родительских к вложенным,
+// чтобы настройки вложенных директорий применялись последними
+func sortedDirectoryGeo(dirs []DirectoryGeo) []DirectoryGeo {
+	sorted := make([]DirectoryGeo, len(dirs))
+	for i, dir := range dirs {
+		dir.Dir = strings.Trim(dir.Dir, "/")
+		sorted[i] = dir
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Dir) < len(sorted[j].Dir)
+	})
+	return sorted
+}
+
+// checkGeoModes проверяет режимы местоположения в настройках
+func checkGeoModes(options Options) error {
+	modes := []GeoMode{options.Geo.Mode}
+	for _, dir := range options.DirectoryGeo {
+		if dir.Override.Mode != nil {
+			modes = append(modes, *dir.Override.Mode)
+		}
+	}
+	for _, mode := range modes {
+		switch mode {
+		case "", GeoKeep, GeoSet, GeoRemove:
+		default:
+			return errors.Errorf("unknown geo mode %q, expected %s, %s or %s", mode, GeoKeep, GeoSet, GeoRemove)
+		}
+	}
+	return nil
+}
+
+// geo возвращает местоположение фото на Flickr с учётом настроек директорий
+func (b *metaBuilder) geo(info flickruploader.PhotoInfo) flickruploader.Geo {
+	options := b.options.Geo
+	for _, dir := range b.directoryGeo {
+		if inDir(info.Dir, dir.Dir) {
+			options = dir.Override.Apply(options)
+		}
+	}
+
+	// координаты из трека Flickr сам не импортирует, поэтому в режиме GeoKeep они устанавливаются
+	mode := options.Mode
+	if info.Geotagged && (mode == "" || mode == GeoKeep) {
+		mode = GeoSet
+	}
+
+	switch mode {
+	case GeoSet:
+		if !info.HasGPS {
+			return flickruploader.Geo{}
+		}
+		return flickruploader.Geo{
+			Latitude:  info.Latitude,
+			Longitude: info.Longitude,
+			Accuracy:  options.Accuracy,
+			Perms:     options.Perms,
+		}
+	case GeoRemove:
+		// без координат в EXIF или XMP удалять нечего
+		if !info.HasGPS || info.Geotagged {
+			return flickruploader.Geo{}
+		}
+		return flickruploader.Geo{Remove: true}
+	}
+	return flickruploader.Geo{}
+}
+
+// applyGeo устанавливает местоположение загруженного фото и записывает его в базу.
+// Ошибка API не мешает загрузке, местоположение можно применить позже командой set-privacy
+func (s *Service) applyGeo(photoPath, photoID string, geo flickruploader.Geo) error {
+	if geo.IsZero() {
+		return nil
+	}
+
+	log.Printf("Set geo of %q (%s): %s", photoPath, photoID, geo)
+	if err := s.remoteStorage.SetGeo(photoID, geo); err != nil {
+		s.reportError(errors.Wrapf(err, "Can't set geo of %q", photoPath))
+		return nil
+	}
+	if err := s.dbStorage.PhotosSetGeo(photoID, geo.String()); err != nil {
+		return errors.Wrapf(err, "Can't record geo of %q", photoPath)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package uploader
+
+import (
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// GroupRule это правило отправки фото в пулы групп. Фото подходит, если его путь подходит под один из шаблонов
+// и у него есть один из тегов; пустой список условий не проверяется
+type GroupRule struct {
+	// Groups ID групп, например 12345678@N01
+	Groups []string
+	// Globs шаблоны пути относительно директории с фото: "*" внутри компонента пути, "**" - любые директории
+	Globs []string
+	// Tags теги Flickr без учёта регистра
+	Tags []string
+}
+
+// match проверяет, что фото с тегами tags подходит под правило
+func (r GroupRule) match(info flickruploader.PhotoInfo, tags []string) bool {
+	if !matchGlobs(r.Globs, info.RelPath) {
+		return false
+	}
+	if len(r.Tags) > 0 {
+		for _, ruleTag := range r.Tags {
+			for _, tag := range tags {
+				if strings.EqualFold(tag, ruleTag) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// checkGroupRules проверяет правила групп
+func checkGroupRules(rules []GroupRule) error {
+	for i, rule := range rules {
+		if len(rule.Groups) == 0 {
+			return errors.Errorf("group rule #%d has no groups", i+1)
+		}
+		if len(rule.Globs) == 0 && len(rule.Tags) == 0 {
+			return errors.Errorf("group rule #%d has neither globs nor tags", i+1)
+		}
+		for _, glob := range rule.Globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return errors.Wrapf(err, "invalid glob %q in group rule #%d", glob, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// queueGroups ставит загруженное фото в очередь на отправку в пулы подходящих групп, см. SyncGroups.
+// Фото, загруженные до появления правила, в группы не отправляются
+func (s *Service) queueGroups(photoID string, info flickruploader.PhotoInfo, meta flickruploader.UploadMeta) error {
+	for _, rule := range s.options.Groups {
+		if !rule.match(info, meta.Tags) {
+			continue
+		}
+		for _, groupID := range rule.Groups {
+			if err := s.dbStorage.GroupsQueue(groupID, photoID); err != nil {
+				return errors.Wrapf(err, "Can't queue %q for group %s", info.Path, groupID)
+			}
+		}
+	}
+	return nil
+}
+
+// SyncGroups отправляет фото из очереди в пулы групп. Перед отправкой у группы запрашивается, сколько фото
+// ещё можно добавить: лишние фото и фото, на которых группа ответила ограничением, остаются в очереди до
+// следующего запуска. Фото, которое приняла или отклонила группа, повторно не отправляется
+func (s *Service) SyncGroups() error {
+	if s.isStopped() || len(s.options.Groups) == 0 {
+		return nil
+	}
+
+	queued, err := s.dbStorage.GroupsGetQueued()
+	if err != nil {
+		return errors.Wrap(err, "Can't get queued group photos from db storage")
+	}
+	groupIDs := make([]string, 0, len(queued))
+	for groupID := range queued {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	for _, groupID := range groupIDs {
+		if s.isStopped() {
+			return nil
+		}
+		if err := s.submitToGroup(groupID, queued[groupID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// submitToGroup отправляет фото в пул группы с учётом ограничения группы. Ошибки API записываются в отчёт
+func (s *Service) submitToGroup(groupID string, photoIDs []string) error {
+	remaining, err := s.remoteStorage.GroupRemaining(groupID)
+	if err != nil {
+		s.reportError(errors.Wrapf(err, "Can't get throttle of group %s", groupID))
+		return nil
+	}
+	if remaining == 0 {
+		log.Printf("Group %s doesn't accept photos now. %d photos wait for the next run", groupID, len(photoIDs))
+		return nil
+	}
+	log.Printf("Add photos to group %s. Count: %d ..", groupID, len(photoIDs))
+
+	for i, photoID := range photoIDs {
+		if s.isStopped() {
+			return nil
+		}
+		if remaining > 0 && i >= remaining {
+			log.Printf("Group %s limit is reached. %d photos wait for the next run", groupID, len(photoIDs)-i)
+			return nil
+		}
+
+		state, err := s.remoteStorage.AddPhotoToGroup(photoID, groupID)
+		var reason string
+		switch {
+		case state == flickruploader.GroupQueued && err != nil:
+			log.Printf("%s. It waits for the next run", err)
+			continue
+		case state == flickruploader.GroupQueued:
+			log.Printf("Group %s doesn't accept photo %s now. %d photos wait for the next run", groupID, photoID, len(photoIDs)-i)
+			return nil
+		case state == "":
+			s.reportError(err)
+			continue
+		case err != nil:
+			log.Printf("%s", err)
+			reason = errors.Cause(err).Error()
+		}
+
+		log.Printf("Photo %s in group %s: %s", photoID, groupID, state)
+		if err := s.dbStorage.GroupsSetState(groupID, photoID, state, reason); err != nil {
+			return errors.Wrapf(err, "Can't record state of photo %s in group %s", photoID, groupID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package uploader
+
+import (
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// membershipBatchSize столько ожидающих фото добавляются в фотосет на Flickr не дожидаясь конца загрузки
+const membershipBatchSize = 500
+
+// flushMemberships добавляет в фотосеты и умные альбомы на Flickr фото, записанные в базу как ожидающие,
+// в том числе оставшиеся от прерванных запусков. Фото одного фотосета добавляются одним запросом. При ошибке фото
+// остаются ожидающими и добавятся при следующем запуске или после следующих membershipBatchSize фото фотосета
+func (s *Service) flushMemberships() error {
+	pending, err := s.dbStorage.PhotosGetPendingSets()
+	if err != nil {
+		return errors.Wrap(err, "Can't get pending set membership from db storage")
+	}
+	smartPending, err := s.dbStorage.SmartAlbumsGetPending()
+	if err != nil {
+		return errors.Wrap(err, "Can't get pending smart album membership from db storage")
+	}
+	for setID, photoIDs := range smartPending {
+		pending[setID] = photoIDs
+	}
+
+	setIDs := make([]string, 0, len(pending))
+	for setID := range pending {
+		setIDs = append(setIDs, setID)
+	}
+	sort.Strings(setIDs)
+
+	for _, setID := range setIDs {
+		photoIDs := pending[setID]
+		delete(s.pendingMembers, setID)
+		log.Printf("Add %d photos to photoset %s", len(photoIDs), setID)
+		err := s.remoteStorage.AddPhotosToPhotoset(setID, photoIDs)
+		if err != nil {
+			s.reportError(errors.Wrapf(err, "Can't add photos to photoset %s", setID))
+			continue
+		}
+
+		if _, smart := smartPending[setID]; smart {
+			err = s.dbStorage.SmartAlbumsClearPending(setID, photoIDs)
+		} else {
+			err = s.dbStorage.PhotosClearPending(setID, photoIDs)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Can't record set membership of photoset %s", setID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package uploader
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// TagOptions это настройки тегов загружаемых фото
+type TagOptions struct {
+	// Static теги, которые ставятся всем фото
+	Static []string
+	// DirComponents ставить тег для каждого компонента относительного пути директории, "2019/italy" -> 2019, italy
+	DirComponents bool
+	// Templates шаблоны text/template над flickruploader.PhotoInfo. Результат разбивается на теги по запятым
+	Templates []string
+	// Embedded ставить теги из ключевых слов IPTC и XMP
+	Embedded bool
+}
+
+// metaBuilder формирует метаданные загружаемых фото
+type metaBuilder struct {
+	options             Options
+	directoryPrivacy    []DirectoryPrivacy
+	directoryGeo        []DirectoryGeo
+	datePatterns        []*regexp.Regexp
+	naming              NamingOptions
+	photosetTemplate    *template.Template
+	tagTemplates        []*template.Template
+	titleTemplate       *template.Template
+	descriptionTemplate *template.Template
+	rules               []compiledRule
+}
+
+// newMetaBuilder разбирает шаблоны. Пустой шаблон названия оставляет название, которое flickr берёт из имени файла
+func newMetaBuilder(options Options) (*metaBuilder, error) {
+	builder := &metaBuilder{
+		options:          options,
+		directoryPrivacy: sortedDirectoryPrivacy(options.DirectoryPrivacy),
+		directoryGeo:     sortedDirectoryGeo(options.DirectoryGeo),
+	}
+	if err := checkGeoModes(options); err != nil {
+		return nil, err
+	}
+	if err := checkAlbumOptions(options.Albums); err != nil {
+		return nil, err
+	}
+	if err := checkSmartAlbums(options.SmartAlbums); err != nil {
+		return nil, err
+	}
+	if err := checkGroupRules(options.Groups); err != nil {
+		return nil, err
+	}
+	rules, err := compileRules(options.Rules)
+	if err != nil {
+		return nil, err
+	}
+	builder.rules = rules
+	datePatterns, err := compileDatePatterns(options.Dates.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	builder.datePatterns = datePatterns
+	builder.naming, builder.photosetTemplate, err = newPhotosetNaming(options.PhotosetNaming)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, text := range options.Tags.Templates {
+		tmpl, err := template.New("tag").Parse(text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't parse tag template #%d %q", i+1, text)
+		}
+		builder.tagTemplates = append(builder.tagTemplates, tmpl)
+	}
+
+	if options.TitleTemplate != "" {
+		builder.titleTemplate, err = template.New("title").Parse(options.TitleTemplate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't parse title template %q", options.TitleTemplate)
+		}
+	}
+	if options.DescriptionTemplate != "" {
+		builder.descriptionTemplate, err = template.New("description").Parse(options.DescriptionTemplate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't parse description template %q", options.DescriptionTemplate)
+		}
+	}
+	return builder, nil
+}
+
+// build формирует метаданные фото
+func (b *metaBuilder) build(info flickruploader.PhotoInfo) (flickruploader.UploadMeta, error) {
+	tags, err := b.tags(info)
+	if err != nil {
+		return flickruploader.UploadMeta{}, err
+	}
+	meta := flickruploader.UploadMeta{
+		Tags:    tags,
+		Privacy: b.privacy(info),
+		Geo:     b.geo(info),
+	}
+	meta.DateTaken, meta.DatePosted = b.dates(info)
+
+	titleTemplate := b.titleTemplate
+	for _, rule := range b.matchingRules(info) {
+		if rule.title != nil {
+			titleTemplate = rule.title
+		}
+		if rule.License != "" {
+			meta.License = rule.License
+		}
+	}
+	if titleTemplate != nil {
+		title, err := render(titleTemplate, info)
+		if err != nil {
+			return flickruploader.UploadMeta{}, err
+		}
+		meta.Title = strings.TrimSpace(title)
+	}
+	if b.descriptionTemplate != nil {
+		description, err := render(b.descriptionTemplate, info)
+		if err != nil {
+			return flickruploader.UploadMeta{}, err
+		}
+		meta.Description = strings.TrimSpace(description)
+	}
+
+	if b.options.EmbeddedTitle {
+		if info.Headline != "" {
+			meta.Title = info.Headline
+		} else if info.Title != "" {
+			meta.Title = info.Title
+		}
+	}
+	if b.options.EmbeddedDescription && info.Caption != "" {
+		meta.Description = info.Caption
+	}
+	return meta, nil
+}
+
+// skipReason возвращает причину, по которой фото не загружается из-за правил загрузки или рейтинга,
+// пустую строку если фото загружается
+func (b *metaBuilder) skipReason(info flickruploader.PhotoInfo) string {
+	if rule, skip, decided := b.ruleSkip(info); decided {
+		if skip {
+			return fmt.Sprintf("skipped by rule %s", rule)
+		}
+		// правило явно разрешает загрузку: рейтинг не проверяется
+		return ""
+	}
+	if b.options.SkipRejected && info.Rating < 0 {
+		return "rejected"
+	}
+	if b.options.MinRating > 0 && info.Rating < b.options.MinRating {
+		return fmt.Sprintf("rating %d is below %d", info.Rating, b.options.MinRating)
+	}
+	return ""
+}
+
+// remoteTitle возвращает название фото на flickr: заданное шаблоном или имя файла без расширения
+func remoteTitle(meta flickruploader.UploadMeta, info flickruploader.PhotoInfo) string {
+	if meta.Title != "" {
+		return meta.Title
+	}
+	return info.Name
+}
+
+// tags возвращает теги фото без повторов
+func (b *metaBuilder) tags(info flickruploader.PhotoInfo) ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	add := func(tag string) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[strings.ToLower(tag)] {
+			return
+		}
+		seen[strings.ToLower(tag)] = true
+		tags = append(tags, tag)
+	}
+
+	for _, tag := range b.options.Tags.Static {
+		add(tag)
+	}
+	if b.options.Tags.DirComponents {
+		for _, part := range info.DirParts {
+			add(part)
+		}
+	}
+	for _, tmpl := range b.tagTemplates {
+		rendered, err := render(tmpl, info)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range strings.Split(rendered, ",") {
+			add(tag)
+		}
+	}
+	if b.options.Tags.Embedded {
+		for _, keyword := range info.Keywords {
+			add(keyword)
+		}
+	}
+	for _, rule := range b.matchingRules(info) {
+		for _, tag := range rule.Tags {
+			add(tag)
+		}
+	}
+	return tags, nil
+}
+
+// render выполняет шаблон над данными
+func render(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "can't render template %q", tmpl.Name())
+	}
+	return buf.String(), nil
+}
+
+// photoInfo возвращает сведения о файле вместе с метаданными EXIF, IPTC и XMP.
+// Дата съёмки фото без EXIF определяется по пути или дате изменения, координаты фото без GPS ищутся по GPX трекам,
+// если известно время съёмки, см. resolveDate.
+// Ошибка чтения метаданных не мешает загрузке, фото загружается без них
+func (s *Service) photoInfo(path string) (flickruploader.PhotoInfo, error) {
+	info, err := s.fileManager.GetPhotoInfo(path)
+	if err != nil {
+		return flickruploader.PhotoInfo{}, err
+	}
+	if info.MediaType != flickruploader.MediaPhoto {
+		return info, nil
+	}
+
+	info.Metadata, err = s.metadataReader.Read(path)
+	if err != nil {
+		log.Printf("Can't read metadata of %q: %s", path, err)
+	}
+	precise := s.resolveDate(&info)
+
+	if !info.HasGPS && precise {
+		if point, ok := s.geotagger.Locate(path, info.DateTaken); ok {
+			info.HasGPS = true
+			info.Geotagged = true
+			info.Latitude = point.Latitude
+			info.Longitude = point.Longitude
+			info.Altitude = point.Altitude
+		}
+	}
+	return info, nil
+}
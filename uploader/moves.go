@@ -0,0 +1,255 @@
+package uploader
+
+import (
+	"log"
+	"sort"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// DetectMoves находит среди новых файлов загруженные фото, которые переместили или переименовали локально.
+// Фото опознаются по хэшу содержимого: вместо удаления и повторной загрузки в базе меняется путь.
+// Если все фото фотосета переместились в одну директорию, фотосет переименовывается на Flickr.
+// Вызывается после SetFilesToProcess
+func (s *Service) DetectMoves() error {
+	if s.isStopped() {
+		return nil
+	}
+
+	hashes, err := s.hashUploaded()
+	if err != nil {
+		return err
+	}
+	if len(s.photoIDsToDelete) == 0 || len(s.pathsToUpload) == 0 {
+		return nil
+	}
+
+	// удалённые локально фото, ключ - хэш
+	missing := map[string]string{}
+	for _, photoID := range s.photoIDsToDelete {
+		if hash := hashes[photoID]; hash != "" {
+			missing[hash] = photoID
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	pathsByID := map[string]string{}
+	for path, photoID := range s.dbFiles {
+		pathsByID[photoID] = path
+	}
+
+	log.Printf("Looking for moved photos among new files. Count: %d ..", len(s.pathsToUpload))
+	// новый путь перемещённых фото, ключ - ID фото
+	moved := map[string]string{}
+	for _, path := range s.pathsToUpload {
+		if s.isStopped() {
+			return nil
+		}
+		hash, err := s.fileHash(path)
+		if err != nil {
+			log.Printf("Can't hash %q: %s", path, err)
+			continue
+		}
+		photoID, ok := missing[hash]
+		if !ok {
+			continue
+		}
+		delete(missing, hash)
+
+		log.Printf("Photo %s moved: %q -> %q", photoID, pathsByID[photoID], path)
+		err = s.dbStorage.PhotosMove(photoID, path, s.fileManager.GetRawPath(path))
+		if err != nil {
+			return errors.Wrapf(err, "Can't move photo %s in db storage", photoID)
+		}
+		delete(s.dbFiles, pathsByID[photoID])
+		s.dbFiles[path] = photoID
+		moved[photoID] = path
+		s.report.Moved++
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+
+	s.pathsToUpload = withoutPaths(s.pathsToUpload, moved)
+	var toDelete []string
+	for _, photoID := range s.photoIDsToDelete {
+		if _, ok := moved[photoID]; !ok {
+			toDelete = append(toDelete, photoID)
+		}
+	}
+	s.photoIDsToDelete = toDelete
+
+	return s.renameMovedSets(moved)
+}
+
+// hashUploaded записывает хэши загруженных фото, которых ещё нет в базе: фото загруженных старыми версиями
+// или восстановленных после падения. Перемещённое фото опознаётся только по хэшу, записанному до перемещения,
+// поэтому хэшируются все такие фото: первый запуск новой версии читает фото, загруженные старыми версиями.
+// Хэш кэшируется вместе с метаданными, см. fileHash. Возвращает хэши всех фото, ключ - ID фото
+func (s *Service) hashUploaded() (map[string]string, error) {
+	hashes, err := s.dbStorage.PhotosGetHashes()
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't get hashes of photos from db storage")
+	}
+
+	var paths []string
+	for path, photoID := range s.dbFiles {
+		if hashes[photoID] == "" && s.fileManager.Exists(path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return hashes, nil
+	}
+	sort.Strings(paths)
+
+	log.Printf("Hashing uploaded photos. Count: %d ..", len(paths))
+	for _, path := range paths {
+		if s.isStopped() {
+			return hashes, nil
+		}
+		photoID := s.dbFiles[path]
+		hash, err := s.fileHash(path)
+		if err != nil {
+			log.Printf("Can't hash %q: %s", path, err)
+			continue
+		}
+		if err := s.dbStorage.PhotosSetHash(photoID, hash); err != nil {
+			return nil, errors.Wrapf(err, "Can't record hash of %q", path)
+		}
+		hashes[photoID] = hash
+	}
+	return hashes, nil
+}
+
+// renameMovedSets переименовывает фотосеты, все фото которых переместились в директорию с другим названием альбома.
+// Части разделённого альбома переименовываются с сохранением ключа части
+func (s *Service) renameMovedSets(moved map[string]string) error {
+	setIDs, err := s.dbStorage.PhotosGetSetIDs()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets of photos from db storage")
+	}
+	sets, err := s.dbStorage.SetsGetAll()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets from db storage")
+	}
+
+	members := map[string][]string{}
+	for photoID, setID := range setIDs {
+		members[setID] = append(members[setID], photoID)
+	}
+
+	for setID, photoIDs := range members {
+		set := sets[setID]
+		newName, ok := s.movedSetName(photoIDs, moved)
+		if !ok || newName == set.Album {
+			continue
+		}
+		parts, err := s.dbStorage.SetsGetParts(newName)
+		if err != nil {
+			return errors.Wrapf(err, "Can't get photosets of album '%s'", newName)
+		}
+		if partTaken(parts, set.Part) {
+			log.Printf("Photoset '%s' is moved to '%s', but that photoset exists. Keep the name", set.Name, newName)
+			continue
+		}
+
+		renamed := set
+		renamed.Album = newName
+		renamed.Name = partTitle(newName, set.Part)
+		log.Printf("Rename photoset '%s' (%s) to '%s'", set.Name, setID, renamed.Name)
+		if err := s.remoteStorage.RenamePhotoset(setID, renamed.Name); err != nil {
+			s.reportError(errors.Wrapf(err, "Can't rename photoset '%s'", set.Name))
+			continue
+		}
+		if err := s.dbStorage.SetsUpdate(renamed); err != nil {
+			return errors.Wrapf(err, "Can't rename photoset %s in db storage", setID)
+		}
+		// название из .album.yml восстановится при синхронизации альбомов
+		if err := s.dbStorage.SetsSetAlbumHash(setID, ""); err != nil {
+			return errors.Wrapf(err, "Can't reset album hash of photoset %s", setID)
+		}
+		s.touchSet(setID)
+	}
+	return nil
+}
+
+// partTaken проверяет, что у альбома уже есть часть part. Неразделённый альбом занимает любую часть
+func partTaken(parts []flickruploader.Photoset, part string) bool {
+	for _, existing := range parts {
+		if existing.Part == part || existing.Part == "" || part == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// movedSetName возвращает новое название фотосета, если все его фото переместились и получают одно название
+func (s *Service) movedSetName(photoIDs []string, moved map[string]string) (string, bool) {
+	var name string
+	for i, photoID := range photoIDs {
+		path, ok := moved[photoID]
+		if !ok {
+			return "", false
+		}
+		photoInfo, err := s.photoInfo(path)
+		if err != nil {
+			log.Printf("Can't get info of %q: %s", path, err)
+			return "", false
+		}
+		photoName, err := s.metaBuilder.photosetName(photoInfo)
+		if err != nil {
+			log.Printf("Can't get photoset name of %q: %s", path, err)
+			return "", false
+		}
+		if photoName == "" || (i > 0 && photoName != name) {
+			return "", false
+		}
+		name = photoName
+	}
+	return name, name != ""
+}
+
+// withoutPaths возвращает пути, которых нет среди значений excluded
+func withoutPaths(paths []string, excluded map[string]string) []string {
+	skip := map[string]bool{}
+	for _, path := range excluded {
+		skip[path] = true
+	}
+	var result []string
+	for _, path := range paths {
+		if !skip[path] {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// recordHash записывает хэш загруженного фото. Хэш из метаданных есть только у фото, остальные файлы хэшируются.
+// Ошибка не мешает загрузке, хэш запишется при следующем запуске
+func (s *Service) recordHash(photoPath, photoID, hash string) {
+	if hash == "" {
+		var err error
+		hash, err = s.fileHash(photoPath)
+		if err != nil {
+			log.Printf("Can't hash %q: %s", photoPath, err)
+			return
+		}
+	}
+	if err := s.dbStorage.PhotosSetHash(photoID, hash); err != nil {
+		log.Printf("Can't record hash of %q: %s", photoPath, err)
+	}
+}
+
+// fileHash возвращает SHA1 содержимого файла. Хэш кэшируется вместе с метаданными и не пересчитывается,
+// пока не изменились размер или время изменения файла
+func (s *Service) fileHash(path string) (string, error) {
+	meta, err := s.metadataReader.Read(path)
+	if err != nil {
+		return "", err
+	}
+	return meta.Hash, nil
+}
@@ -0,0 +1,192 @@
+package uploader
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// OrphanAction это действие, предлагаемое для фото-сироты
+type OrphanAction string
+
+// Действия с фото-сиротами
+const (
+	// OrphanAdopt записать фото в базу как загруженное для найденного локального файла
+	OrphanAdopt OrphanAction = "adopt"
+	// OrphanDelete удалить фото с Flickr: локального файла нет или он уже загружен другим фото
+	OrphanDelete OrphanAction = "delete"
+)
+
+// Orphan это фото на Flickr с тегом программы, которого нет в базе
+type Orphan struct {
+	Photo flickruploader.RemotePhoto
+	// LocalPath сопоставленный локальный файл
+	LocalPath string
+	// Duplicate локальный файл уже загружен другим фото
+	Duplicate bool
+	Action    OrphanAction
+}
+
+// FindOrphans ищет на Flickr фото с тегом программы, которых нет в базе, и сопоставляет их с локальными файлами
+// по имени файла и дате. Требует InitPhotos
+func (s *Service) FindOrphans() ([]Orphan, error) {
+	log.Println("Getting all uploaded photos from Flickr...")
+	remotePhotos, err := s.remoteStorage.ListUploadedPhotos()
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't list uploaded photos")
+	}
+
+	knownIDs := map[string]bool{}
+	for _, photoID := range s.dbFiles {
+		knownIDs[photoID] = true
+	}
+
+	// фото ищутся и по имени файла и по названию из шаблона
+	localByTitle := map[string][]string{}
+	for _, path := range s.photoFiles {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		localByTitle[name] = append(localByTitle[name], path)
+		if title := s.localTitle(path); title != "" && title != name {
+			localByTitle[title] = append(localByTitle[title], path)
+		}
+	}
+
+	var orphans []Orphan
+	adopted := map[string]bool{}
+	for _, photo := range remotePhotos {
+		if knownIDs[photo.ID] {
+			continue
+		}
+
+		orphan := Orphan{Photo: photo, Action: OrphanDelete}
+		orphan.LocalPath = s.closestByDate(localByTitle[photo.Title], photo.DateTaken)
+		if orphan.LocalPath != "" {
+			_, inDB := s.dbFiles[orphan.LocalPath]
+			orphan.Duplicate = inDB || adopted[orphan.LocalPath]
+			if !orphan.Duplicate {
+				orphan.Action = OrphanAdopt
+				adopted[orphan.LocalPath] = true
+			}
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	log.Printf("Found %d orphans among %d uploaded photos", len(orphans), len(remotePhotos))
+	return orphans, nil
+}
+
+// localTitle возвращает название локального файла по шаблону, пустая строка если шаблона нет
+func (s *Service) localTitle(path string) string {
+	if s.metaBuilder.titleTemplate == nil {
+		return ""
+	}
+	photoInfo, err := s.photoInfo(path)
+	if err != nil {
+		log.Printf("Can't get info of %q: %s", path, err)
+		return ""
+	}
+	meta, err := s.metaBuilder.build(photoInfo)
+	if err != nil {
+		log.Printf("Can't build metadata of %q: %s", path, err)
+		return ""
+	}
+	return meta.Title
+}
+
+// closestByDate выбирает из файлов с подходящим именем файл с датой изменения ближайшей к дате съёмки
+func (s *Service) closestByDate(paths []string, dateTaken time.Time) string {
+	var closest string
+	var closestDiff time.Duration
+	for _, path := range paths {
+		fileInfo, err := s.fileManager.Stat(path)
+		if err != nil {
+			continue
+		}
+		diff := fileInfo.ModTime().Sub(dateTaken)
+		if diff < 0 {
+			diff = -diff
+		}
+		if closest == "" || diff < closestDiff {
+			closest, closestDiff = path, diff
+		}
+	}
+	return closest
+}
+
+// ResolveOrphans выводит отчёт о фото-сиротах и выполняет разрешённые действия.
+// Без adopt и remove ничего не меняет (dry run)
+func (s *Service) ResolveOrphans(orphans []Orphan, adopt, remove bool) error {
+	for _, orphan := range orphans {
+		if s.isStopped() {
+			return nil
+		}
+
+		apply := (orphan.Action == OrphanAdopt && adopt) || (orphan.Action == OrphanDelete && remove)
+		mode := "dry run"
+		if apply {
+			mode = "apply"
+		}
+		note := "no local file"
+		if orphan.LocalPath != "" {
+			note = "local file " + orphan.LocalPath
+			if orphan.Duplicate {
+				note += " is already uploaded"
+			}
+		}
+		log.Printf("Orphan %s %q taken %s: %s. %s (%s)",
+			orphan.Photo.ID, orphan.Photo.Title, orphan.Photo.DateTaken.Format(time.RFC3339), note, orphan.Action, mode)
+		if !apply {
+			continue
+		}
+
+		var err error
+		switch orphan.Action {
+		case OrphanAdopt:
+			err = s.adoptOrphan(orphan)
+		case OrphanDelete:
+			err = s.remoteStorage.DeletePhoto(orphan.Photo.ID)
+		}
+		if err != nil {
+			s.reportError(errors.Wrapf(err, "Can't %s orphan %s", orphan.Action, orphan.Photo.ID))
+		}
+	}
+	return s.flushMemberships()
+}
+
+// adoptOrphan записывает фото-сироту в базу так же, как при восстановлении после падения
+func (s *Service) adoptOrphan(orphan Orphan) error {
+	mediaType, err := s.fileManager.GetMediaType(orphan.LocalPath)
+	if err != nil {
+		return errors.Wrapf(err, "Can't get media type of %q", orphan.LocalPath)
+	}
+
+	photoInfo, err := s.photoInfo(orphan.LocalPath)
+	if err != nil {
+		return errors.Wrapf(err, "Can't get info of %q", orphan.LocalPath)
+	}
+	photosetName, err := s.metaBuilder.photosetName(photoInfo)
+	if err != nil {
+		return errors.Wrapf(err, "Can't get photoset name of %q", orphan.LocalPath)
+	}
+
+	err = s.dbStorage.JournalStart(flickruploader.JournalEntry{
+		Path:      orphan.LocalPath,
+		RawPath:   s.fileManager.GetRawPath(orphan.LocalPath),
+		Video:     mediaType == flickruploader.MediaVideo,
+		SetName:   photosetName,
+		StartedAt: orphan.Photo.DateUploaded,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Can't journal adoption of %q", orphan.LocalPath)
+	}
+	err = s.dbStorage.JournalUploaded(orphan.LocalPath, orphan.Photo.ID)
+	if err != nil {
+		return errors.Wrapf(err, "Can't insert photo to db storage %q %q", orphan.LocalPath, orphan.Photo.ID)
+	}
+
+	return s.addToPhotoset(orphan.LocalPath, orphan.Photo.ID, photosetName, true)
+}
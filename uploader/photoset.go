@@ -0,0 +1,125 @@
+package uploader
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// Стратегии именования фотосетов
+const (
+	// NamingPath полный путь директории относительно директории с фото: "2019/italy/rome"
+	NamingPath = "path"
+	// NamingLast последний компонент пути: "rome"
+	NamingLast = "last"
+	// NamingTop директория верхнего уровня: "2019"
+	NamingTop = "top"
+	// NamingYearMonth год и месяц съёмки: "2019-07"
+	NamingYearMonth = "year_month"
+	// NamingTemplate шаблон text/template над flickruploader.PhotoInfo
+	NamingTemplate = "template"
+)
+
+// Что делать с фото в корне директории с фото и с фото, для которых стратегия не дала названия
+const (
+	// RootNone не добавлять в фотосет
+	RootNone = "none"
+	// RootSet добавлять в фотосет с названием RootSet
+	RootSet = "set"
+)
+
+// defaultRootSet название фотосета для фото в корне, как в старых версиях
+const defaultRootSet = "."
+
+// NamingOptions это настройки именования фотосетов
+type NamingOptions struct {
+	// Strategy одна из Naming*, по умолчанию NamingPath
+	Strategy string
+	// Separator разделитель компонентов пути для NamingPath, по умолчанию "/"
+	Separator string
+	// YearMonthLayout формат даты для NamingYearMonth, по умолчанию "2006-01"
+	YearMonthLayout string
+	// Template шаблон для NamingTemplate
+	Template string
+	// Root RootNone или RootSet, по умолчанию RootSet
+	Root string
+	// RootSet название фотосета для Root = RootSet
+	RootSet string
+}
+
+// newPhotosetNaming проверяет настройки именования, заполняет умолчания и разбирает шаблон
+func newPhotosetNaming(options NamingOptions) (NamingOptions, *template.Template, error) {
+	if options.Strategy == "" {
+		options.Strategy = NamingPath
+	}
+	if options.Separator == "" {
+		options.Separator = "/"
+	}
+	if options.YearMonthLayout == "" {
+		options.YearMonthLayout = "2006-01"
+	}
+	if options.Root == "" {
+		options.Root = RootSet
+	}
+	if options.Root == RootSet && options.RootSet == "" {
+		options.RootSet = defaultRootSet
+	}
+
+	switch options.Root {
+	case RootNone, RootSet:
+	default:
+		return options, nil, errors.Errorf("unknown photoset root policy %q, expected %s or %s", options.Root, RootNone, RootSet)
+	}
+
+	switch options.Strategy {
+	case NamingPath, NamingLast, NamingTop, NamingYearMonth:
+		return options, nil, nil
+	case NamingTemplate:
+		tmpl, err := template.New("photoset").Parse(options.Template)
+		if err != nil {
+			return options, nil, errors.Wrapf(err, "can't parse photoset template %q", options.Template)
+		}
+		return options, tmpl, nil
+	}
+	return options, nil, errors.Errorf("unknown photoset naming strategy %q", options.Strategy)
+}
+
+// photosetName возвращает название фотосета для фото, пустую строку если фото не добавляется в фотосет.
+// Альбом, заданный правилами загрузки, заменяет стратегию именования
+func (b *metaBuilder) photosetName(info flickruploader.PhotoInfo) (string, error) {
+	if album, ok := b.ruleAlbum(info); ok {
+		return album, nil
+	}
+	naming := b.naming
+	var name string
+	switch naming.Strategy {
+	case NamingPath:
+		name = strings.Join(info.DirParts, naming.Separator)
+	case NamingLast:
+		if len(info.DirParts) > 0 {
+			name = info.DirParts[len(info.DirParts)-1]
+		}
+	case NamingTop:
+		if len(info.DirParts) > 0 {
+			name = info.DirParts[0]
+		}
+	case NamingYearMonth:
+		if !info.DateTaken.IsZero() {
+			name = info.DateTaken.Format(naming.YearMonthLayout)
+		}
+	case NamingTemplate:
+		rendered, err := render(b.photosetTemplate, info)
+		if err != nil {
+			return "", err
+		}
+		name = rendered
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" && naming.Root == RootSet {
+		return naming.RootSet, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,83 @@
+package uploader
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+)
+
+// Условия правил загрузки, групп и умных альбомов. Пустое условие выполняется для любого фото
+
+// matchGlobs проверяет, что путь относительно директории с фото подходит под один из шаблонов, см. matchGlob
+func matchGlobs(globs []string, relPath string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	relPath = filepath.ToSlash(relPath)
+	for _, glob := range globs {
+		if matchGlob(glob, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob проверяет путь со слэшами по шаблону path.Match, в котором "**" заменяет любое количество директорий
+func matchGlob(pattern, name string) bool {
+	return matchGlobParts(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchYear проверяет год съёмки, 0 - любой. Фото без даты подходит только под любой год
+func matchYear(info flickruploader.PhotoInfo, year int) bool {
+	return year == 0 || (!info.DateTaken.IsZero() && info.DateTaken.Year() == year)
+}
+
+// matchCamera проверяет, что производитель и модель камеры содержат подстроку camera без учёта регистра
+func matchCamera(info flickruploader.PhotoInfo, camera string) bool {
+	return camera == "" || strings.Contains(strings.ToLower(info.Camera), strings.ToLower(camera))
+}
+
+// matchRating проверяет минимальный рейтинг XMP, 0 - любой
+func matchRating(info flickruploader.PhotoInfo, minRating int) bool {
+	return minRating == 0 || info.Rating >= minRating
+}
+
+// hasKeywords проверяет, что у фото есть все ключевые слова keywords без учёта регистра
+func hasKeywords(info flickruploader.PhotoInfo, keywords []string) bool {
+	for _, keyword := range keywords {
+		found := false
+		for _, photoKeyword := range info.Keywords {
+			if strings.EqualFold(photoKeyword, keyword) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package uploader
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// PrivacyOverride переопределяет часть настроек видимости, nil поля не меняются
+type PrivacyOverride struct {
+	IsPublic    *bool
+	IsFriend    *bool
+	IsFamily    *bool
+	SafetyLevel *int
+	ContentType *int
+	Hidden      *bool
+}
+
+// Apply возвращает настройки base с переопределёнными полями
+func (o PrivacyOverride) Apply(base flickruploader.Privacy) flickruploader.Privacy {
+	if o.IsPublic != nil {
+		base.IsPublic = *o.IsPublic
+	}
+	if o.IsFriend != nil {
+		base.IsFriend = *o.IsFriend
+	}
+	if o.IsFamily != nil {
+		base.IsFamily = *o.IsFamily
+	}
+	if o.SafetyLevel != nil {
+		base.SafetyLevel = *o.SafetyLevel
+	}
+	if o.ContentType != nil {
+		base.ContentType = *o.ContentType
+	}
+	if o.Hidden != nil {
+		base.Hidden = *o.Hidden
+	}
+	return base
+}
+
+// DirectoryPrivacy это настройки видимости для директории (относительно директории с фото) и всех вложенных
+type DirectoryPrivacy struct {
+	Dir      string
+	Override PrivacyOverride
+}
+
+// sortedDirectoryPrivacy сортирует настройки директорий от родительских к вложенным,
+// чтобы настройки вложенных директорий применялись последними
+func sortedDirectoryPrivacy(dirs []DirectoryPrivacy) []DirectoryPrivacy {
+	sorted := make([]DirectoryPrivacy, len(dirs))
+	for i, dir := range dirs {
+		dir.Dir = strings.Trim(dir.Dir, "/")
+		sorted[i] = dir
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Dir) < len(sorted[j].Dir)
+	})
+	return sorted
+}
+
+// privacy возвращает настройки видимости фото с учётом настроек директорий и правил загрузки
+func (b *metaBuilder) privacy(info flickruploader.PhotoInfo) flickruploader.Privacy {
+	privacy := b.options.Privacy
+	for _, dir := range b.directoryPrivacy {
+		if inDir(info.Dir, dir.Dir) {
+			privacy = dir.Override.Apply(privacy)
+		}
+	}
+	for _, rule := range b.matchingRules(info) {
+		privacy = rule.Privacy.Apply(privacy)
+	}
+	return privacy
+}
+
+// inDir проверяет, что директория path совпадает с dir или вложена в неё. Пустая dir - корень
+func inDir(path, dir string) bool {
+	return dir == "" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// ApplyPrivacy применяет текущие настройки видимости и местоположения к уже загруженным фото.
+// Без force меняются только фото, у которых записанные в базе настройки отличаются. Требует InitPhotos
+func (s *Service) ApplyPrivacy(force bool) error {
+	applied, err := s.dbStorage.PhotosGetPrivacy()
+	if err != nil {
+		return errors.Wrap(err, "Can't get privacy of photos from db storage")
+	}
+	appliedGeo, err := s.dbStorage.PhotosGetGeo()
+	if err != nil {
+		return errors.Wrap(err, "Can't get geo of photos from db storage")
+	}
+
+	paths := make([]string, 0, len(s.dbFiles))
+	for path := range s.dbFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	changed := 0
+	for _, path := range paths {
+		if s.isStopped() {
+			return nil
+		}
+		photoID := s.dbFiles[path]
+
+		if !s.fileManager.Exists(path) {
+			continue
+		}
+		photoInfo, err := s.photoInfo(path)
+		if err != nil {
+			s.reportError(errors.Wrapf(err, "Can't get info of %q", path))
+			continue
+		}
+		geo := s.metaBuilder.geo(photoInfo)
+		if !geo.IsZero() && (force || appliedGeo[photoID] != geo.String()) {
+			if err := s.applyGeo(path, photoID, geo); err != nil {
+				return err
+			}
+		}
+
+		privacy := s.metaBuilder.privacy(photoInfo)
+		if !force && applied[photoID] == privacy.String() {
+			continue
+		}
+
+		log.Printf("Set privacy of %q (%s): %s", path, photoID, privacy)
+		err = s.remoteStorage.SetPrivacy(photoID, privacy)
+		if err != nil {
+			s.reportError(errors.Wrapf(err, "Can't set privacy of %q", path))
+			continue
+		}
+		err = s.dbStorage.PhotosSetPrivacy(photoID, privacy.String())
+		if err != nil {
+			return errors.Wrapf(err, "Can't record privacy of %q", path)
+		}
+		changed++
+	}
+
+	log.Printf("Privacy changed for %d photos", changed)
+	return nil
+}
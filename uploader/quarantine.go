@@ -0,0 +1,47 @@
+package uploader
+
+import (
+	"log"
+	"os"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// isQuarantined проверяет что файл в карантине и не изменился с тех пор.
+// Изменённый файл выпускается из карантина и проверяется заново
+func (s *Service) isQuarantined(path string, fileInfo os.FileInfo) bool {
+	entry, ok := s.quarantine[path]
+	if !ok {
+		return false
+	}
+	if entry.Size == fileInfo.Size() && entry.ModTime.Unix() == fileInfo.ModTime().Unix() {
+		s.report.Quarantined = append(s.report.Quarantined, entry)
+		return true
+	}
+
+	log.Printf("Quarantined file %q has changed. Check it again", path)
+	delete(s.quarantine, path)
+	if err := s.dbStorage.QuarantineDelete(path); err != nil {
+		log.Printf("Can't release %q from quarantine: %s", path, err)
+	}
+	return false
+}
+
+// quarantineFile помещает в карантин файл не прошедший проверку
+func (s *Service) quarantineFile(path string, fileInfo os.FileInfo, reason error) error {
+	log.Printf("File %q is damaged, put it into quarantine: %s", path, reason)
+	entry := flickruploader.QuarantineEntry{
+		Path:    path,
+		Reason:  reason.Error(),
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+	}
+	err := s.dbStorage.QuarantineInsert(entry)
+	if err != nil {
+		return errors.Wrapf(err, "Can't quarantine %q", path)
+	}
+	s.quarantine[path] = entry
+	s.report.Quarantined = append(s.report.Quarantined, entry)
+	return nil
+}
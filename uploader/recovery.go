@@ -0,0 +1,92 @@
+package uploader
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// Recover доводит до конца загрузки, прерванные падением процесса. Должен вызываться до InitPhotos.
+// Для незавершённой загрузки (pending) фото ищется на Flickr среди фото, которых нет в базе: если найдено
+// ровно одно, оно записывается в базу, иначе запись журнала удаляется и файл загрузится заново.
+// Загруженные фото добавляются в фотосет
+func (s *Service) Recover() error {
+	entries, err := s.dbStorage.JournalGetUnfinished()
+	if err != nil {
+		return errors.Wrap(err, "Can't get unfinished uploads")
+	}
+	if len(entries) > 0 {
+		log.Printf("Recovering unfinished uploads. Count: %d ..", len(entries))
+	}
+
+	dbFiles, err := s.dbStorage.PhotosGetAll()
+	if err != nil {
+		return errors.Wrap(err, "can't get all photos from DB")
+	}
+	// known фото, которые уже принадлежат файлам, их нельзя взять для другого файла
+	known := make(map[string]bool, len(dbFiles))
+	for _, photoID := range dbFiles {
+		known[photoID] = true
+	}
+
+	for _, entry := range entries {
+		if s.isStopped() {
+			return nil
+		}
+		err := s.recoverEntry(entry, known)
+		if err != nil {
+			s.reportError(errors.Wrapf(err, "Can't recover upload of %q", entry.Path))
+		}
+	}
+
+	if err := s.flushMemberships(); err != nil {
+		return err
+	}
+	return s.dbStorage.JournalPurgeFinished()
+}
+
+// recoverEntry доводит до конца одну загрузку. known - ID фото из базы, дополняется найденным фото
+func (s *Service) recoverEntry(entry flickruploader.JournalEntry, known map[string]bool) error {
+	if entry.State == flickruploader.UploadPending {
+		// записи старых версий без названия: Flickr берёт название из имени файла без расширения
+		title := entry.Title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(entry.Path), filepath.Ext(entry.Path))
+		}
+		found, err := s.remoteStorage.FindUploadedPhotos(title, entry.StartedAt)
+		if err != nil {
+			return errors.Wrap(err, "Can't search uploaded photo")
+		}
+		var candidates []string
+		for _, photoID := range found {
+			if !known[photoID] {
+				candidates = append(candidates, photoID)
+			}
+		}
+		switch len(candidates) {
+		case 0:
+			log.Printf("Photo %q was not uploaded. It will be uploaded again", entry.Path)
+			return s.dbStorage.JournalDelete(entry.Path)
+		case 1:
+		default:
+			// название не уникально: чужое фото взять нельзя, возможный дубликат найдёт команда orphans
+			log.Printf("Found %d photos titled '%s' for %q: %v. It will be uploaded again", len(candidates), title, entry.Path, candidates)
+			return s.dbStorage.JournalDelete(entry.Path)
+		}
+		photoID := candidates[0]
+		known[photoID] = true
+
+		log.Printf("Photo %q was uploaded before crash, id=%s", entry.Path, photoID)
+		err = s.dbStorage.JournalUploaded(entry.Path, photoID)
+		if err != nil {
+			return errors.Wrapf(err, "Can't insert photo to db storage %q %q", entry.Path, photoID)
+		}
+		entry.PhotoID = photoID
+	}
+
+	log.Printf("Add recovered photo %q (%s) to photoset '%s'", entry.Path, entry.PhotoID, entry.SetName)
+	return s.addToPhotoset(entry.Path, entry.PhotoID, entry.SetName, true)
+}
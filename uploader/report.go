@@ -0,0 +1,59 @@
+package uploader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+)
+
+// Report это итоги запуска синхронизации
+type Report struct {
+	Uploaded int
+	Deleted  int
+	// Moved загруженные фото, которые переместили локально
+	Moved int
+	// Deferred файлы отложенные до следующего запуска
+	Deferred []string
+	// Skipped файлы пропущенные из-за ограничений
+	Skipped []string
+	// Quarantined повреждённые файлы, в том числе найденные в предыдущих запусках
+	Quarantined []flickruploader.QuarantineEntry
+	// Failed файлы, загрузка которых завершилась ошибкой, и "отравленные" файлы
+	Failed []flickruploader.FailureEntry
+	// NewFailures количество файлов, которые впервые упали в этом запуске или только что стали "отравленными".
+	// Повторные ошибки уже известных файлов не считаются
+	NewFailures int
+	// Errors ошибки, не относящиеся к конкретному файлу: удаление, проверка видео
+	Errors []string
+}
+
+// HasFailures проверяет были ли новые ошибки: известные упавшие и "отравленные" файлы только попадают в отчёт
+func (r Report) HasFailures() bool {
+	return r.NewFailures > 0 || len(r.Errors) > 0
+}
+
+// String форматирует отчёт для вывода в лог
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Uploaded: %d. Deleted: %d. Moved: %d. Deferred: %d. Skipped: %d. Quarantined: %d. Failed: %d.",
+		r.Uploaded, r.Deleted, r.Moved, len(r.Deferred), len(r.Skipped), len(r.Quarantined), len(r.Failed)+len(r.Errors))
+
+	for _, failure := range r.Failed {
+		state := "failed"
+		if failure.Poisoned {
+			state = "poisoned"
+		}
+		fmt.Fprintf(&b, "\n  %s %s (attempts: %d): %s", state, failure.Path, failure.Attempts, failure.LastError)
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(&b, "\n  error: %s", err)
+	}
+	for _, entry := range r.Quarantined {
+		fmt.Fprintf(&b, "\n  quarantined %s: %s", entry.Path, entry.Reason)
+	}
+	for _, path := range r.Skipped {
+		fmt.Fprintf(&b, "\n  skipped %s", path)
+	}
+	return b.String()
+}
@@ -0,0 +1,220 @@
+package uploader
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// Rule это правило загрузки для части дерева. Фото подходит под правило, если выполнены все заданные условия.
+// Правила применяются по порядку: настройки подходящих правил перекрывают общие настройки и настройки более
+// ранних правил, теги правил добавляются к остальным тегам
+type Rule struct {
+	// Name название правила в выводе explain, по умолчанию номер правила
+	Name string
+
+	// Globs шаблоны пути относительно директории с фото, см. matchGlob. Фото должно подходить под один из них
+	Globs []string
+	// Extensions расширения без точки без учёта регистра. Фото должно иметь одно из них
+	Extensions []string
+	// Camera подстрока производителя и модели камеры без учёта регистра
+	Camera string
+	// MinRating минимальный рейтинг XMP, 0 - любой
+	MinRating int
+	// Year год съёмки, 0 - любой
+	Year int
+	// HasGPS у фото есть (true) или нет (false) координат, nil - не проверяется
+	HasGPS *bool
+	// Keywords ключевые слова, которые все должны быть у фото, без учёта регистра
+	Keywords []string
+
+	// Skip не загружать (true) или загружать (false) фото, в том числе с рейтингом ниже MinRating и отклонённые,
+	// nil - не меняется
+	Skip *bool
+	// Privacy переопределение видимости, применяется после настроек директорий
+	Privacy PrivacyOverride
+	// License ID лицензии Flickr, пустая строка - не меняется
+	License string
+	// Tags дополнительные теги
+	Tags []string
+	// Album название фотосета вместо стратегии именования, пустая строка - фото без фотосета, nil - не меняется
+	Album *string
+	// TitleTemplate шаблон названия вместо Options.TitleTemplate, пустая строка - не меняется
+	TitleTemplate string
+}
+
+// compiledRule это правило загрузки с разобранным шаблоном названия
+type compiledRule struct {
+	Rule
+	title *template.Template
+}
+
+// compileRules проверяет правила загрузки и разбирает их шаблоны
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	var compiled []compiledRule
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		for _, glob := range rule.Globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid glob %q in rule %s", glob, rule.Name)
+			}
+		}
+		extensions := make([]string, len(rule.Extensions))
+		for j, ext := range rule.Extensions {
+			extensions[j] = strings.ToLower(strings.TrimPrefix(ext, "."))
+		}
+		rule.Extensions = extensions
+
+		compiledRule := compiledRule{Rule: rule}
+		if rule.TitleTemplate != "" {
+			tmpl, err := template.New("title").Parse(rule.TitleTemplate)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can't parse title template %q of rule %s", rule.TitleTemplate, rule.Name)
+			}
+			compiledRule.title = tmpl
+		}
+		compiled = append(compiled, compiledRule)
+	}
+	return compiled, nil
+}
+
+// match проверяет, что фото подходит под правило
+func (r Rule) match(info flickruploader.PhotoInfo) bool {
+	if !matchGlobs(r.Globs, info.RelPath) {
+		return false
+	}
+	if len(r.Extensions) > 0 {
+		matched := false
+		for _, ext := range r.Extensions {
+			if ext == info.Ext {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.HasGPS != nil && info.HasGPS != *r.HasGPS {
+		return false
+	}
+	return matchYear(info, r.Year) && matchCamera(info, r.Camera) && matchRating(info, r.MinRating) &&
+		hasKeywords(info, r.Keywords)
+}
+
+// matchingRules возвращает правила загрузки, под которые подходит фото, в порядке конфига
+func (b *metaBuilder) matchingRules(info flickruploader.PhotoInfo) []compiledRule {
+	var matched []compiledRule
+	for _, rule := range b.rules {
+		if rule.match(info) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+// ruleSkip возвращает решение правил о загрузке фото: skip - не загружать, rule - последнее правило с заданным Skip.
+// decided false, если правила загрузку не задают
+func (b *metaBuilder) ruleSkip(info flickruploader.PhotoInfo) (rule string, skip, decided bool) {
+	for _, matched := range b.matchingRules(info) {
+		if matched.Skip != nil {
+			rule, skip, decided = matched.Name, *matched.Skip, true
+		}
+	}
+	return rule, skip, decided
+}
+
+// ruleAlbum возвращает название фотосета, заданное правилами. ok false, если правила фотосет не меняют
+func (b *metaBuilder) ruleAlbum(info flickruploader.PhotoInfo) (album string, ok bool) {
+	for _, rule := range b.matchingRules(info) {
+		if rule.Album != nil {
+			album, ok = strings.TrimSpace(*rule.Album), true
+		}
+	}
+	return album, ok
+}
+
+// Explanation это настройки загрузки файла и правила, из которых они получены
+type Explanation struct {
+	Path string
+	// Rules названия правил загрузки, под которые подходит файл, в порядке применения
+	Rules []string
+	// SkipReason причина, по которой файл не загружается, пустая строка если загружается
+	SkipReason string
+	Meta       flickruploader.UploadMeta
+	// Photoset название альбома, пустая строка если фото не добавляется в фотосет
+	Photoset string
+}
+
+// String выводит объяснение построчно
+func (e Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "File:        %s\n", e.Path)
+	rules := "none"
+	if len(e.Rules) > 0 {
+		rules = strings.Join(e.Rules, ", ")
+	}
+	fmt.Fprintf(&b, "Rules:       %s\n", rules)
+	if e.SkipReason != "" {
+		fmt.Fprintf(&b, "Skip:        %s\n", e.SkipReason)
+		return b.String()
+	}
+	license := e.Meta.License
+	if license == "" {
+		license = "default"
+	}
+	fmt.Fprintf(&b, "Privacy:     %s\n", e.Meta.Privacy)
+	fmt.Fprintf(&b, "License:     %s\n", license)
+	fmt.Fprintf(&b, "Tags:        %s\n", strings.Join(e.Meta.Tags, ", "))
+	fmt.Fprintf(&b, "Photoset:    %s\n", e.Photoset)
+	fmt.Fprintf(&b, "Title:       %s\n", e.Meta.Title)
+	fmt.Fprintf(&b, "Description: %s\n", e.Meta.Description)
+	return b.String()
+}
+
+// Explain возвращает настройки, с которыми загружается файл, и правила загрузки, под которые он подходит.
+// Не обращается к Flickr и не меняет базу загрузок, в базе может только пополниться кэш метаданных файла
+func (s *Service) Explain(path string) (Explanation, error) {
+	photoInfo, err := s.photoInfo(path)
+	if err != nil {
+		return Explanation{}, errors.Wrapf(err, "Can't get info of %q", path)
+	}
+	explanation := Explanation{Path: path}
+	for _, rule := range s.metaBuilder.matchingRules(photoInfo) {
+		explanation.Rules = append(explanation.Rules, rule.Name)
+	}
+
+	explanation.SkipReason = s.metaBuilder.skipReason(photoInfo)
+	if explanation.SkipReason != "" {
+		return explanation, nil
+	}
+	explanation.Meta, err = s.metaBuilder.build(photoInfo)
+	if err != nil {
+		return Explanation{}, errors.Wrapf(err, "Can't build metadata of %q", path)
+	}
+	explanation.Photoset, err = s.metaBuilder.photosetName(photoInfo)
+	if err != nil {
+		return Explanation{}, errors.Wrapf(err, "Can't get photoset name of %q", path)
+	}
+	return explanation, nil
+}
+
+// applyLicense устанавливает лицензию загруженного фото, заданную правилами.
+// Ошибка API не мешает загрузке: фото остаётся с лицензией по умолчанию
+func (s *Service) applyLicense(photoPath, photoID string, meta flickruploader.UploadMeta) {
+	if meta.License == "" {
+		return
+	}
+
+	log.Printf("Set license of %q (%s): %s", photoPath, photoID, meta.License)
+	if err := s.remoteStorage.SetLicense(photoID, meta.License); err != nil {
+		s.reportError(errors.Wrapf(err, "Can't set license of %q", photoPath))
+	}
+}
@@ -2,27 +2,85 @@ package uploader
 
 import (
 	"log"
+	"os"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/denisov/flickr-uploader-go"
 	"github.com/pkg/errors"
 )
 
+// Options это настройки сервиса синхронизации
+type Options struct {
+	// MaxAttempts после стольких неудачных попыток файл пропускается пока не изменится, 0 - без ограничения
+	MaxAttempts int
+	// RetryDelay пауза перед повторной попыткой загрузки, удваивается после каждой неудачи
+	RetryDelay time.Duration
+	Tags       TagOptions
+	// TitleTemplate шаблон text/template названия фото над flickruploader.PhotoInfo
+	TitleTemplate string
+	// DescriptionTemplate шаблон text/template описания фото над flickruploader.PhotoInfo
+	DescriptionTemplate string
+	// EmbeddedTitle брать название из IPTC/XMP Headline или Title, если оно есть, вместо шаблона
+	EmbeddedTitle bool
+	// EmbeddedDescription брать описание из IPTC/XMP Caption, если оно есть, вместо шаблона
+	EmbeddedDescription bool
+	// MinRating фото с рейтингом XMP ниже не загружаются, 0 - без ограничения
+	MinRating int
+	// SkipRejected не загружать отклонённые фото (рейтинг XMP -1)
+	SkipRejected bool
+	// Privacy видимость загружаемых фото
+	Privacy flickruploader.Privacy
+	// DirectoryPrivacy переопределение видимости для директорий
+	DirectoryPrivacy []DirectoryPrivacy
+	// Geo местоположение загружаемых фото
+	Geo GeoOptions
+	// DirectoryGeo переопределение местоположения для директорий
+	DirectoryGeo []DirectoryGeo
+	Dates        DateOptions
+	// PhotosetNaming именование фотосетов
+	PhotosetNaming NamingOptions
+	// Albums порядок фото и обложка альбомов
+	Albums AlbumOptions
+	// Collections коллекции по директориям верхнего уровня
+	Collections CollectionOptions
+	// SmartAlbums правила умных альбомов
+	SmartAlbums []SmartAlbumRule
+	// Groups правила отправки фото в пулы групп
+	Groups []GroupRule
+	// Rules правила загрузки для частей дерева в порядке применения
+	Rules []Rule
+}
+
 // Service это сервис синхронизации файлов на flickr
 type Service struct {
+	options     Options
+	metaBuilder *metaBuilder
+
 	stopped      bool
 	mutexStopped sync.Mutex
 
 	photoFiles []string
 	dbFiles    map[string]string // FIXME описать или упростить формат
+	dbRawPaths map[string]string // RAW файлы загруженных фото, ключ - путь фото
+	quarantine map[string]flickruploader.QuarantineEntry
+	failures   map[string]flickruploader.FailureEntry
+
+	report Report
+	// touchedSets фотосеты, состав которых изменился в этом запуске, см. SyncAlbums
+	touchedSets map[string]bool
+	// pendingMembers количество фото, ожидающих добавления в фотосет на Flickr, ключ - ID фотосета
+	pendingMembers map[string]int
 
 	pathsToUpload    []string // файлы на загрузку
 	photoIDsToDelete []string // ID файлов на удаление
 
-	fileManager   flickruploader.Filemanager
-	dbStorage     flickruploader.DBStorage
-	remoteStorage flickruploader.RemoteStorage
+	fileManager    flickruploader.Filemanager
+	dbStorage      flickruploader.DBStorage
+	remoteStorage  flickruploader.RemoteStorage
+	metadataReader flickruploader.MetadataReader
+	geotagger      flickruploader.Geotagger
 }
 
 // NewService возвращает сервис синхронизации (загрузки)
@@ -30,13 +88,27 @@ func NewService(
 	fileManager flickruploader.Filemanager,
 	dbstorage flickruploader.DBStorage,
 	remoteStorage flickruploader.RemoteStorage,
-) *Service {
-	return &Service{
-		fileManager:   fileManager,
-		dbStorage:     dbstorage,
-		remoteStorage: remoteStorage,
-		stopped:       false,
+	metadataReader flickruploader.MetadataReader,
+	geotagger flickruploader.Geotagger,
+	options Options,
+) (*Service, error) {
+	metaBuilder, err := newMetaBuilder(options)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid metadata options")
 	}
+
+	return &Service{
+		options:        options,
+		metaBuilder:    metaBuilder,
+		fileManager:    fileManager,
+		dbStorage:      dbstorage,
+		remoteStorage:  remoteStorage,
+		metadataReader: metadataReader,
+		geotagger:      geotagger,
+		touchedSets:    map[string]bool{},
+		pendingMembers: map[string]int{},
+		stopped:        false,
+	}, nil
 }
 
 // Stop взводит флаг остановки сервиса
@@ -71,10 +143,28 @@ func (s *Service) InitPhotos() error {
 	log.Println("Getting all photos in DB")
 	dbFiles, err := s.dbStorage.PhotosGetAll()
 	if err != nil {
-		errors.Wrap(err, "can't get all photos from DB")
+		return errors.Wrap(err, "can't get all photos from DB")
 	}
 	s.dbFiles = dbFiles
 
+	dbRawPaths, err := s.dbStorage.PhotosGetRawPaths()
+	if err != nil {
+		return errors.Wrap(err, "can't get raw paths from DB")
+	}
+	s.dbRawPaths = dbRawPaths
+
+	quarantine, err := s.dbStorage.QuarantineGetAll()
+	if err != nil {
+		return errors.Wrap(err, "can't get quarantine from DB")
+	}
+	s.quarantine = quarantine
+
+	failures, err := s.dbStorage.FailuresGetAll()
+	if err != nil {
+		return errors.Wrap(err, "can't get failures from DB")
+	}
+	s.failures = failures
+
 	return nil
 }
 
@@ -82,9 +172,15 @@ func (s *Service) InitPhotos() error {
 func (s *Service) SetFilesToProcess() {
 	// TODO вынести в ф-ции загрузки и удаления?
 
+	// RAW файлы, которые уже загружены в паре с JPEG или как превью
+	uploadedRaws := map[string]bool{}
+	for _, rawPath := range s.dbRawPaths {
+		uploadedRaws[rawPath] = true
+	}
+
 	// to upload to Flickr - local photos that not in DB
 	for _, path := range s.photoFiles {
-		if _, ok := s.dbFiles[path]; !ok {
+		if _, ok := s.dbFiles[path]; !ok && !uploadedRaws[path] {
 			s.pathsToUpload = append(s.pathsToUpload, path)
 		}
 	}
@@ -96,61 +192,282 @@ func (s *Service) SetFilesToProcess() {
 	// возвращает индекс в который надо вставить
 	for path, photoID := range s.dbFiles {
 		if idx := sort.SearchStrings(s.photoFiles, path); idx == len(s.photoFiles) || s.photoFiles[idx] != path {
+			// пока жив RAW файл фото не удаляем, удалён только JPEG из пары
+			if rawPath := s.dbRawPaths[path]; rawPath != "" && s.fileManager.Exists(rawPath) {
+				log.Printf("Photo %q is deleted but its RAW %q exists. Keep it on Flickr", path, rawPath)
+				continue
+			}
 			s.photoIDsToDelete = append(s.photoIDsToDelete, photoID)
 		}
 	}
 }
 
-// Upload загружает фото в удалённое хранилище
+// Upload загружает фото в удалённое хранилище.
+// Ошибка загрузки одного файла не останавливает загрузку остальных, она записывается в очередь ошибок
 func (s *Service) Upload() error {
 	if s.isStopped() {
 		return nil
 	}
 	log.Printf("Uploading new photos. Count:%d ..", len(s.pathsToUpload))
 
+	var candidates []string
+	fileInfos := map[string]os.FileInfo{}
 	for _, photoPathItem := range s.pathsToUpload {
+		fileInfo, err := s.fileManager.Stat(photoPathItem)
+		if err != nil {
+			log.Printf("Can't stat %q: %s", photoPathItem, err)
+			continue
+		}
+		if s.isWaitingRetry(photoPathItem, fileInfo) || s.isQuarantined(photoPathItem, fileInfo) {
+			continue
+		}
+		candidates = append(candidates, photoPathItem)
+		fileInfos[photoPathItem] = fileInfo
+	}
+	// файлы могут ещё копироваться, тогда они загрузятся при следующем запуске
+	notReady := s.fileManager.CheckReady(candidates)
+
+	for _, photoPathItem := range candidates {
 		if s.isStopped() {
 			return nil
 		}
+		if err := notReady[photoPathItem]; err != nil {
+			log.Printf("Defer %q to the next run: %s", photoPathItem, err)
+			s.report.Deferred = append(s.report.Deferred, photoPathItem)
+			continue
+		}
+
+		fileInfo := fileInfos[photoPathItem]
+		err := s.uploadFile(photoPathItem, fileInfo)
+		if err != nil {
+			log.Printf("%+v", err)
+			if err := s.recordFailure(photoPathItem, fileInfo, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.clearFailure(photoPathItem); err != nil {
+			return err
+		}
+	}
+
+	return s.flushMemberships()
+}
+
+// uploadFile загружает один файл, добавляет его в фотосет и записывает в базу
+func (s *Service) uploadFile(photoPath string, fileInfo os.FileInfo) error {
+	mediaType, err := s.fileManager.GetMediaType(photoPath)
+	if err != nil {
+		return errors.Wrapf(err, "Can't get media type of %q", photoPath)
+	}
+	if mediaType == flickruploader.MediaVideo {
+		if err := s.fileManager.CheckVideoLimits(photoPath); err != nil {
+			log.Printf("Skip video %q: %s", photoPath, err)
+			s.report.Skipped = append(s.report.Skipped, photoPath)
+			return nil
+		}
+	}
+
+	photoInfo, err := s.photoInfo(photoPath)
+	if err != nil {
+		return errors.Wrapf(err, "Can't get info of %q", photoPath)
+	}
+	if reason := s.metaBuilder.skipReason(photoInfo); reason != "" {
+		log.Printf("Skip %q: %s", photoPath, reason)
+		s.report.Skipped = append(s.report.Skipped, photoPath)
+		return nil
+	}
+
+	meta, err := s.metaBuilder.build(photoInfo)
+	if err != nil {
+		return errors.Wrapf(err, "Can't build metadata of %q", photoPath)
+	}
+
+	// местоположение, которым управляет программа, устанавливается после загрузки: до этого координаты EXIF
+	// были бы видны с правами аккаунта по умолчанию
+	uploadPath, cleanup, err := s.fileManager.PrepareUpload(photoPath, !meta.Geo.IsZero())
+	if err != nil {
+		return errors.Wrapf(err, "Can't prepare photo %q for upload", photoPath)
+	}
+	defer cleanup()
+	if err := s.fileManager.Validate(uploadPath); err != nil {
+		return s.quarantineFile(photoPath, fileInfo, err)
+	}
+
+	photosetName, err := s.metaBuilder.photosetName(photoInfo)
+	if err != nil {
+		return errors.Wrapf(err, "Can't get photoset name of %q", photoPath)
+	}
+
+	// журналируем загрузку до вызова API, чтобы после падения найти загруженное фото, см. Recover
+	err = s.dbStorage.JournalStart(flickruploader.JournalEntry{
+		Path:      photoPath,
+		RawPath:   s.fileManager.GetRawPath(photoPath),
+		Video:     mediaType == flickruploader.MediaVideo,
+		SetName:   photosetName,
+		Title:     remoteTitle(meta, photoInfo),
+		StartedAt: time.Now(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Can't journal upload of %q", photoPath)
+	}
+
+	photoID, err := s.remoteStorage.UploadPhoto(uploadPath, meta)
+	if err != nil {
+		return errors.Wrapf(err, "Can't upload photo %q", photoPath)
+	}
+	log.Printf("File Uploaded. %s ==> %s ", photoPath, photoID)
+	s.report.Uploaded++
+
+	// видео считается загруженным только после того как Flickr его обработает, см. CheckVideos
+	err = s.dbStorage.JournalUploaded(photoPath, photoID)
+	if err != nil {
+		return errors.Wrapf(err, "Can't insert photo to db storage %q %q", photoPath, photoID)
+	}
+	s.recordHash(photoPath, photoID, photoInfo.Hash)
+	err = s.dbStorage.PhotosSetSortKey(photoID, sortKey(photoInfo))
+	if err != nil {
+		return errors.Wrapf(err, "Can't record sort key of %q", photoPath)
+	}
+	err = s.dbStorage.PhotosSetPrivacy(photoID, meta.Privacy.String())
+	if err != nil {
+		return errors.Wrapf(err, "Can't record privacy of %q", photoPath)
+	}
+	if meta.Geo.Remove {
+		// координаты удалены из загруженной копии, на Flickr удалять нечего
+		if err := s.dbStorage.PhotosSetGeo(photoID, meta.Geo.String()); err != nil {
+			return errors.Wrapf(err, "Can't record geo of %q", photoPath)
+		}
+	} else if err := s.applyGeo(photoPath, photoID, meta.Geo); err != nil {
+		return err
+	}
+	s.applyDates(photoPath, photoID, meta)
+	s.applyLicense(photoPath, photoID, meta)
+
+	log.Printf("Add photo %s(%s) to photoset '%s'", photoInfo.FileName, photoID, photosetName)
+	if err := s.addToPhotoset(photoPath, photoID, photosetName, false); err != nil {
+		return err
+	}
+	return s.queueGroups(photoID, photoInfo, meta)
+}
+
+// addToPhotoset создаёт фотосет или записывает фото в существующий и завершает запись журнала.
+// Фотосет выбирается среди частей альбома photosetName, см. choosePhotoset.
+// В существующий фотосет фото добавляется на Flickr позже вместе с другими, см. flushMemberships.
+// При восстановлении (recovering) фотосет, которого нет в базе, сначала ищется на Flickr:
+// процесс мог упасть между созданием фотосета и записью его в базу. Пустое название - фото без фотосета
+func (s *Service) addToPhotoset(photoPath, photoID, photosetName string, recovering bool) error {
+	if photosetName == "" {
+		err := s.dbStorage.JournalInSet(photoPath, photoID, flickruploader.Photoset{}, false, false)
+		if err != nil {
+			return errors.Wrapf(err, "Can't finish upload of photo %s without photoset", photoID)
+		}
+		return nil
+	}
+
+	set, newSet, err := s.choosePhotoset(photoPath, photosetName)
+	if err != nil {
+		return err
+	}
 
-		photoID, err := s.remoteStorage.UploadPhoto(photoPathItem)
+	if newSet && recovering {
+		set.ID, err = s.remoteStorage.FindPhotoset(set.Name)
 		if err != nil {
-			return errors.Wrapf(err, "Can't upload photo %q", photoPathItem)
+			return errors.Wrapf(err, "Can't find photoset %q", set.Name)
 		}
-		log.Printf("File Uploaded. %s ==> %s ", photoPathItem, photoID)
+		if set.ID != "" {
+			log.Printf("Photoset '%s' found on Flickr, id=%s", set.Name, set.ID)
+		}
+	}
 
-		err = s.dbStorage.PhotosInsert(photoPathItem, photoID)
+	pending := set.ID != ""
+	if !pending {
+		log.Printf("Photoset '%s' doesn't exists. Create it. Main photo=%s", set.Name, photoID)
+		set.ID, err = s.remoteStorage.CreatePhotoset(set.Name, photoID)
 		if err != nil {
-			return errors.Wrapf(err, "Can't insert photo to db storage %q %q", photoPathItem, photoID)
+			return errors.Wrapf(err, "Can't create photoset %s %s", set.Name, photoID)
+		}
+	}
+
+	err = s.dbStorage.JournalInSet(photoPath, photoID, set, newSet, pending)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set photoset %s for photo %s", set.ID, photoID)
+	}
+	s.touchSet(set.ID)
+
+	if pending {
+		s.pendingMembers[set.ID]++
+		if s.pendingMembers[set.ID] >= membershipBatchSize {
+			return s.flushMemberships()
+		}
+	}
+	return nil
+}
+
+// reportError записывает в отчёт ошибку, после которой обработка продолжается
+func (s *Service) reportError(err error) {
+	log.Printf("%+v", err)
+	s.report.Errors = append(s.report.Errors, err.Error())
+}
+
+// Report возвращает итоги запуска
+func (s *Service) Report() Report {
+	return s.report
+}
+
+// CheckVideos проверяет статус обработки загруженных видео.
+// Готовые видео помечаются как загруженные, видео которые Flickr не смог обработать удаляются с Flickr и из базы,
+// чтобы загрузиться заново при следующем запуске
+func (s *Service) CheckVideos() error {
+	if s.isStopped() {
+		return nil
+	}
+
+	videoIDs, err := s.dbStorage.PhotosGetByVideoStatus(flickruploader.VideoPending)
+	if err != nil {
+		return errors.Wrap(err, "Can't get pending videos from db storage")
+	}
+	log.Printf("Checking videos processing status. Count: %d ..", len(videoIDs))
+	if len(videoIDs) == 0 {
+		return nil
+	}
+	setIDs, err := s.dbStorage.PhotosGetSetIDs()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets of photos from db storage")
+	}
+
+	for _, videoID := range videoIDs {
+		if s.isStopped() {
+			return nil
 		}
 
-		// создаём фотосет или добавляем в существующий
-		photosetName, fileName := s.fileManager.ParsePath(photoPathItem)
-		photosetID, err := s.dbStorage.SetsGetIDByName(photosetName)
+		status, err := s.remoteStorage.GetVideoStatus(videoID)
 		if err != nil {
-			return errors.Wrapf(err, "Can't get set name from db storage by name %q", photosetName)
+			s.reportError(errors.Wrapf(err, "Can't get video status %q", videoID))
+			continue
 		}
-		if photosetID == "" {
-			log.Printf("Photoset '%s' doesn't exists. Create it. Main photo=%s(%s)", photosetName, fileName, photoID)
-			photosetID, err := s.remoteStorage.CreatePhotoset(photosetName, photoID)
+
+		switch status {
+		case flickruploader.VideoReady:
+			log.Printf("Video %s is processed", videoID)
+			err = s.dbStorage.PhotosSetVideoStatus(videoID, flickruploader.VideoReady)
 			if err != nil {
-				return errors.Wrapf(err, "Can't create photoset %s %s", photosetName, photoID)
+				return errors.Wrapf(err, "Can't set video status %q", videoID)
 			}
-			err = s.dbStorage.SetsInsert(photosetID, photosetName)
+		case flickruploader.VideoFailed:
+			log.Printf("Flickr failed to process video %s. Delete it to upload again next time", videoID)
+			err = s.remoteStorage.DeletePhoto(videoID)
 			if err != nil {
-				return errors.Wrapf(err, "Can't insert photoset %s %s", photosetID, photosetName)
+				s.reportError(errors.Wrapf(err, "Can't delete video %q from remote storage", videoID))
+				continue
 			}
-		} else {
-			log.Printf("Photoset '%s' exists, id=%s. Add photo %s(%s) to photoset", photosetName, photosetID, fileName, photoID)
-			err = s.remoteStorage.AddPhotoToPhotoset(photoID, photosetID)
+			err = s.dbStorage.PhotosDelete(videoID)
 			if err != nil {
-				return errors.Wrapf(err, "Can't add photo %q to photoset %q", photoID, photosetID)
+				return errors.Wrapf(err, "Can't delete video %q from db storage", videoID)
 			}
-		}
-
-		err = s.dbStorage.PhotosAddToSet(photoID, photosetID)
-		if err != nil {
-			return errors.Wrapf(err, "Can't set photoset %s for photo %s", photosetID, photoID)
+			s.touchSet(setIDs[videoID])
+		default:
+			log.Printf("Video %s is still processing", videoID)
 		}
 	}
 
@@ -163,6 +480,13 @@ func (s *Service) Delete() error {
 		return nil
 	}
 	log.Printf("Deleting photos from Flickr. Count: %d ..", len(s.photoIDsToDelete))
+	if len(s.photoIDsToDelete) == 0 {
+		return nil
+	}
+	setIDs, err := s.dbStorage.PhotosGetSetIDs()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets of photos from db storage")
+	}
 
 	for _, photoID := range s.photoIDsToDelete {
 		if s.isStopped() {
@@ -173,14 +497,17 @@ func (s *Service) Delete() error {
 
 		err := s.remoteStorage.DeletePhoto(photoID)
 		if err != nil {
-			return errors.Wrapf(err, "Can't delete photo %q from remote storage", photoID)
+			s.reportError(errors.Wrapf(err, "Can't delete photo %q from remote storage", photoID))
+			continue
 		}
 
 		err = s.dbStorage.PhotosDelete(photoID)
 		if err != nil {
 			return errors.Wrapf(err, "Can't delete photo %q from db storage", photoID)
 		}
+		s.touchSet(setIDs[photoID])
+		s.report.Deleted++
 	}
 
-	return nil
+	return s.deleteEmptySets()
 }
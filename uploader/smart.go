@@ -0,0 +1,222 @@
+package uploader
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// SmartAlbumRule это правило умного альбома. Фото попадает в альбом, если выполнены все заданные условия,
+// и в каждый альбом, правилу которого соответствует, независимо от альбома директории
+type SmartAlbumRule struct {
+	// Name название альбома на Flickr
+	Name string
+	// Year год съёмки, 0 - любой
+	Year int
+	// Camera подстрока производителя и модели камеры без учёта регистра
+	Camera string
+	// MinRating минимальный рейтинг XMP, 0 - любой
+	MinRating int
+	// Keywords ключевые слова, которые все должны быть у фото, без учёта регистра
+	Keywords []string
+	// Dir директория относительно директории с фото, в которой лежит фото, вместе с поддиректориями
+	Dir string
+}
+
+// String сериализует правило для определения изменений
+func (r SmartAlbumRule) String() string {
+	return fmt.Sprintf("name=%q year=%d camera=%q rating=%d keywords=%q dir=%q",
+		r.Name, r.Year, r.Camera, r.MinRating, r.Keywords, r.Dir)
+}
+
+// match проверяет, что фото соответствует правилу
+func (r SmartAlbumRule) match(info flickruploader.PhotoInfo) bool {
+	if r.Dir != "" && !inDir(info.Dir, strings.Trim(r.Dir, "/")) {
+		return false
+	}
+	return matchYear(info, r.Year) && matchCamera(info, r.Camera) && matchRating(info, r.MinRating) &&
+		hasKeywords(info, r.Keywords)
+}
+
+// checkSmartAlbums проверяет правила умных альбомов
+func checkSmartAlbums(rules []SmartAlbumRule) error {
+	names := map[string]bool{}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return errors.Errorf("smart album #%d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return errors.Errorf("smart album '%s' is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Year == 0 && rule.Camera == "" && rule.MinRating == 0 && len(rule.Keywords) == 0 && rule.Dir == "" {
+			return errors.Errorf("smart album '%s' has no conditions", rule.Name)
+		}
+	}
+	return nil
+}
+
+// smartKey возвращает ключ набора правил: фото, проверенные по другому набору, проверяются заново
+func smartKey(rules []SmartAlbumRule) string {
+	hash := sha1.New()
+	for _, rule := range rules {
+		fmt.Fprintln(hash, rule.String())
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// SyncSmartAlbums добавляет загруженные фото в умные альбомы, правилам которых они соответствуют, и убирает
+// из альбомов фото, которые перестали соответствовать. Проверяются фото, которые ещё не проверялись по текущим
+// правилам: новые, перемещённые и все фото после изменения правил. Альбомы, удалённые из конфига, не меняются
+func (s *Service) SyncSmartAlbums() error {
+	rules := s.options.SmartAlbums
+	if s.isStopped() || len(rules) == 0 {
+		return nil
+	}
+	key := smartKey(rules)
+
+	keys, err := s.dbStorage.PhotosGetSmartKeys()
+	if err != nil {
+		return errors.Wrap(err, "Can't get smart keys of photos from db storage")
+	}
+	dbFiles, err := s.dbStorage.PhotosGetAll()
+	if err != nil {
+		return errors.Wrap(err, "can't get all photos from DB")
+	}
+	var paths []string
+	for path, photoID := range dbFiles {
+		if keys[photoID] != key && s.fileManager.Exists(path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+
+	albums, err := s.dbStorage.SmartAlbumsGetAll()
+	if err != nil {
+		return errors.Wrap(err, "Can't get smart albums from db storage")
+	}
+	memberList, err := s.dbStorage.SmartAlbumsGetMembers()
+	if err != nil {
+		return errors.Wrap(err, "Can't get smart album photos from db storage")
+	}
+	members := map[string]map[string]bool{}
+	for name, photoIDs := range memberList {
+		members[name] = map[string]bool{}
+		for _, photoID := range photoIDs {
+			members[name][photoID] = true
+		}
+	}
+
+	log.Printf("Checking photos against smart album rules. Count: %d ..", len(paths))
+	// toRemove фото, которые перестали соответствовать правилу, ключ - название альбома
+	toRemove := map[string][]string{}
+	// unavailable альбомы, фотосет которых не удалось создать в этом запуске
+	unavailable := map[string]bool{}
+	var checked []string
+	for _, path := range paths {
+		if s.isStopped() {
+			break
+		}
+		photoID := dbFiles[path]
+		photoInfo, err := s.photoInfo(path)
+		if err != nil {
+			log.Printf("Can't get info of %q: %s", path, err)
+			continue
+		}
+
+		ok := true
+		for _, rule := range rules {
+			match, in := rule.match(photoInfo), members[rule.Name][photoID]
+			switch {
+			case match && !in && unavailable[rule.Name]:
+				ok = false
+			case match && !in:
+				added, err := s.addToSmartAlbum(albums, rule.Name, photoID)
+				if err != nil {
+					return err
+				}
+				if !added {
+					unavailable[rule.Name] = true
+					ok = false
+				}
+			case !match && in:
+				toRemove[rule.Name] = append(toRemove[rule.Name], photoID)
+			}
+		}
+		if ok {
+			checked = append(checked, photoID)
+		}
+	}
+
+	names := make([]string, 0, len(toRemove))
+	for name := range toRemove {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// failed фото, которые не удалось убрать из альбома, проверятся при следующем запуске
+	failed := map[string]bool{}
+	for _, name := range names {
+		photoIDs := toRemove[name]
+		if setID := albums[name].SetID; setID != "" {
+			log.Printf("Remove %d photos from smart album '%s'", len(photoIDs), name)
+			if err := s.remoteStorage.RemovePhotosFromPhotoset(setID, photoIDs); err != nil {
+				s.reportError(errors.Wrapf(err, "Can't remove photos from smart album '%s'", name))
+				for _, photoID := range photoIDs {
+					failed[photoID] = true
+				}
+				continue
+			}
+		}
+		if err := s.dbStorage.SmartAlbumsRemovePhotos(name, photoIDs); err != nil {
+			return errors.Wrapf(err, "Can't remove photos from smart album '%s' in db storage", name)
+		}
+	}
+
+	for _, photoID := range checked {
+		if failed[photoID] {
+			continue
+		}
+		if err := s.dbStorage.PhotosSetSmartKey(photoID, key); err != nil {
+			return errors.Wrapf(err, "Can't record smart key of photo %s", photoID)
+		}
+	}
+
+	if err := s.flushMemberships(); err != nil {
+		return err
+	}
+	return s.deleteEmptySets()
+}
+
+// addToSmartAlbum записывает фото в умный альбом. Фотосет альбома создаётся с первым фото, остальные фото
+// добавляются на Flickr позже вместе с другими, см. flushMemberships. albums - умные альбомы по названию,
+// дополняется созданными. Ошибка создания фотосета записывается в отчёт, тогда added false
+func (s *Service) addToSmartAlbum(albums map[string]flickruploader.SmartAlbum, name, photoID string) (added bool, err error) {
+	album := albums[name]
+	if album.SetID != "" {
+		if err := s.dbStorage.SmartAlbumsAddPhoto(name, photoID); err != nil {
+			return false, errors.Wrapf(err, "Can't add photo %s to smart album '%s' in db storage", photoID, name)
+		}
+		return true, nil
+	}
+
+	log.Printf("Smart album '%s' doesn't exists. Create it. Main photo=%s", name, photoID)
+	setID, err := s.remoteStorage.CreatePhotoset(name, photoID)
+	if err != nil {
+		s.reportError(errors.Wrapf(err, "Can't create smart album '%s'", name))
+		return false, nil
+	}
+	album = flickruploader.SmartAlbum{Name: name, SetID: setID}
+	if err := s.dbStorage.SmartAlbumsCreate(album, photoID); err != nil {
+		return false, errors.Wrapf(err, "Can't record smart album '%s' in db storage", name)
+	}
+	albums[name] = album
+	return true, nil
+}
@@ -0,0 +1,401 @@
+package uploader
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/denisov/flickr-uploader-go"
+	"github.com/pkg/errors"
+)
+
+// Деление альбома, в котором больше AlbumOptions.MaxSize фото, на фотосеты-части
+const (
+	// SplitNumber нумерованные части "Name (1)", "Name (2)"... Новые фото добавляются в первую неполную часть,
+	// поэтому место удалённых фото занимают новые. Переполненные фотосеты делятся после загрузки, см. SplitAlbums
+	SplitNumber = "number"
+	// SplitDay части по дням съёмки "Name (2019-07-14)". Альбом делится после загрузки, см. SplitAlbums,
+	// затем новые фото добавляются в часть своего дня. Части одного дня не делятся
+	SplitDay = "day"
+)
+
+// undatedPart ключ части для фото без даты съёмки при делении по дням
+const undatedPart = "undated"
+
+// partTitle возвращает название фотосета-части альбома
+func partTitle(album, part string) string {
+	if part == "" {
+		return album
+	}
+	return fmt.Sprintf("%s (%s)", album, part)
+}
+
+// choosePhotoset выбирает фотосет альбома для фото. Новый фотосет (newSet) ещё не создан на Flickr и не имеет ID
+func (s *Service) choosePhotoset(photoPath, album string) (flickruploader.Photoset, bool, error) {
+	parts, err := s.dbStorage.SetsGetParts(album)
+	if err != nil {
+		return flickruploader.Photoset{}, false, errors.Wrapf(err, "Can't get photosets of album '%s'", album)
+	}
+	if len(parts) == 0 {
+		return flickruploader.Photoset{Name: album, Album: album}, true, nil
+	}
+	maxSize := s.options.Albums.MaxSize
+	if maxSize == 0 {
+		return parts[len(parts)-1], false, nil
+	}
+
+	if s.options.Albums.Split == SplitDay {
+		if len(parts) == 1 && parts[0].Part == "" {
+			return parts[0], false, nil
+		}
+		day, err := s.photoDay(photoPath)
+		if err != nil {
+			return flickruploader.Photoset{}, false, err
+		}
+		for _, part := range parts {
+			if part.Part == day {
+				return part, false, nil
+			}
+		}
+		return flickruploader.Photoset{Name: partTitle(album, day), Album: album, Part: day}, true, nil
+	}
+
+	for _, part := range parts {
+		if part.Size < maxSize {
+			return part, false, nil
+		}
+	}
+	// все части заполнены: первая часть неразделённого альбома получает номер, следующая создаётся
+	next := 1
+	for _, part := range parts {
+		if part.Part == "" {
+			if err := s.renamePart(part, "1"); err != nil {
+				return flickruploader.Photoset{}, false, err
+			}
+			part.Part = "1"
+		}
+		if number, err := strconv.Atoi(part.Part); err == nil && number >= next {
+			next = number + 1
+		}
+	}
+	part := strconv.Itoa(next)
+	return flickruploader.Photoset{Name: partTitle(album, part), Album: album, Part: part}, true, nil
+}
+
+// photoDay возвращает день съёмки фото - ключ части при делении по дням
+func (s *Service) photoDay(photoPath string) (string, error) {
+	photoInfo, err := s.photoInfo(photoPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "Can't get info of %q", photoPath)
+	}
+	return dayPart(photoInfo.DateTaken), nil
+}
+
+// dayPart возвращает ключ части для даты съёмки
+func dayPart(taken time.Time) string {
+	if taken.IsZero() {
+		return undatedPart
+	}
+	return taken.Format("2006-01-02")
+}
+
+// renamePart делает фотосет частью part своего альбома на Flickr и в базе. Настройки файла альбома
+// применятся к фотосету заново, см. SyncAlbums
+func (s *Service) renamePart(set flickruploader.Photoset, part string) error {
+	name := partTitle(set.Album, part)
+	log.Printf("Rename photoset '%s' (%s) to '%s'", set.Name, set.ID, name)
+	if err := s.remoteStorage.RenamePhotoset(set.ID, name); err != nil {
+		return errors.Wrapf(err, "Can't rename photoset '%s'", set.Name)
+	}
+
+	set.Name, set.Part = name, part
+	if err := s.dbStorage.SetsUpdate(set); err != nil {
+		return errors.Wrapf(err, "Can't update photoset %s in db storage", set.ID)
+	}
+	if err := s.dbStorage.SetsSetAlbumHash(set.ID, ""); err != nil {
+		return errors.Wrapf(err, "Can't reset album hash of photoset %s", set.ID)
+	}
+	s.touchSet(set.ID)
+	return nil
+}
+
+// SplitAlbums делит альбомы, в которых больше AlbumOptions.MaxSize фото. При делении по дням неразделённый фотосет
+// альбома становится частью дня, в котором больше всего фото, фото остальных дней переносятся в части своих дней.
+// При делении по номерам в фотосете остаются первые по дате съёмки MaxSize фото, остальные переносятся в новые части.
+// Неразделённый фотосет альбома, у которого уже есть части (деление прервалось), делится независимо от размера.
+// Сначала из прежних фотосетов удаляются фото, перенесённые ранее, см. flushRemovals. Вызывается после Upload и Delete
+func (s *Service) SplitAlbums() error {
+	maxSize := s.options.Albums.MaxSize
+	if s.isStopped() || maxSize == 0 {
+		return nil
+	}
+
+	failed, err := s.flushRemovals()
+	if err != nil {
+		return err
+	}
+
+	sets, err := s.dbStorage.SetsGetAll()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets from db storage")
+	}
+	partCounts := map[string]int{}
+	for _, set := range sets {
+		partCounts[set.Album]++
+	}
+	byNumber := s.options.Albums.Split != SplitDay
+	var toSplit []flickruploader.Photoset
+	for _, set := range sets {
+		// фотосет, из которого не удалось убрать перенесённые фото, делится после их удаления
+		if failed[set.ID] {
+			continue
+		}
+		unsplit := set.Part == "" && (set.Size > maxSize || partCounts[set.Album] > 1)
+		if unsplit || (byNumber && set.Size > maxSize) {
+			toSplit = append(toSplit, set)
+		}
+	}
+	if len(toSplit) == 0 {
+		return nil
+	}
+	sort.Slice(toSplit, func(i, j int) bool { return toSplit[i].Name < toSplit[j].Name })
+
+	dbFiles, err := s.dbStorage.PhotosGetAll()
+	if err != nil {
+		return errors.Wrap(err, "can't get all photos from DB")
+	}
+	setIDs, err := s.dbStorage.PhotosGetSetIDs()
+	if err != nil {
+		return errors.Wrap(err, "Can't get photosets of photos from db storage")
+	}
+	sortKeys, err := s.dbStorage.PhotosGetSortKeys()
+	if err != nil {
+		return errors.Wrap(err, "Can't get sort keys of photos from db storage")
+	}
+	members := map[string][]string{}
+	for path, photoID := range dbFiles {
+		if setID := setIDs[photoID]; setID != "" {
+			members[setID] = append(members[setID], path)
+		}
+	}
+
+	for _, set := range toSplit {
+		if s.isStopped() {
+			return nil
+		}
+		paths := members[set.ID]
+		sort.Strings(paths)
+		keys, err := s.photoSortKeys(paths, dbFiles, sortKeys)
+		if err != nil {
+			return err
+		}
+		parts, err := s.dbStorage.SetsGetParts(set.Album)
+		if err != nil {
+			return errors.Wrapf(err, "Can't get photosets of album '%s'", set.Album)
+		}
+		existing := map[string]flickruploader.Photoset{}
+		for _, part := range parts {
+			if part.Part != "" {
+				existing[part.Part] = part
+			}
+		}
+
+		var groups []partGroup
+		var keep string
+		if byNumber {
+			groups, keep, err = numberGroups(set, paths, dbFiles, keys, existing, maxSize)
+		} else {
+			groups, keep = dayGroups(paths, dbFiles, keys, existing)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Split photoset '%s' (%s) of %d photos into %d photosets", set.Name, set.ID, len(paths), len(groups)+1)
+		if err := s.splitSet(set, groups, keep, existing); err != nil {
+			return err
+		}
+	}
+	if err := s.flushMemberships(); err != nil {
+		return err
+	}
+	return s.deleteEmptySets()
+}
+
+// partGroup это фото, которые переносятся в часть part альбома
+type partGroup struct {
+	part     string
+	photoIDs []string
+}
+
+// dayGroups делит фото фотосета по дням съёмки. Фотосет остаётся у самого многочисленного дня, для которого ещё
+// нет части (keep), пустая строка - такого дня нет. paths - фото фотосета, dbFiles - ID фото по пути,
+// keys - ключи упорядочивания по пути, existing - части альбома по ключу части
+func dayGroups(paths []string, dbFiles map[string]string, keys map[string]flickruploader.SortKey,
+	existing map[string]flickruploader.Photoset) (groups []partGroup, keep string) {
+	days := map[string][]string{}
+	for _, path := range paths {
+		day := dayPart(keys[path].DateTaken)
+		days[day] = append(days[day], dbFiles[path])
+	}
+	dayList := make([]string, 0, len(days))
+	for day := range days {
+		dayList = append(dayList, day)
+	}
+	sort.Strings(dayList)
+
+	for _, day := range dayList {
+		if _, ok := existing[day]; !ok && (keep == "" || len(days[day]) > len(days[keep])) {
+			keep = day
+		}
+	}
+	for _, day := range dayList {
+		if day != keep {
+			groups = append(groups, partGroup{part: day, photoIDs: days[day]})
+		}
+	}
+	return groups, keep
+}
+
+// numberGroups делит фото фотосета на нумерованные части по maxSize фото в порядке съёмки. Первые maxSize фото
+// остаются в фотосете: он сохраняет свой номер или получает первый свободный (keep), остальные переносятся
+// в новые части со следующими свободными номерами. Параметры как у dayGroups
+func numberGroups(set flickruploader.Photoset, paths []string, dbFiles map[string]string,
+	keys map[string]flickruploader.SortKey, existing map[string]flickruploader.Photoset,
+	maxSize int) (groups []partGroup, keep string, err error) {
+	sorted, err := sortPhotos(paths, SortDate, keys)
+	if err != nil {
+		return nil, "", err
+	}
+
+	number := 0
+	nextFree := func() string {
+		for {
+			number++
+			part := strconv.Itoa(number)
+			if _, ok := existing[part]; !ok {
+				return part
+			}
+		}
+	}
+	keep = set.Part
+	if keep == "" {
+		keep = nextFree()
+	}
+	for start := maxSize; start < len(sorted); start += maxSize {
+		end := start + maxSize
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		group := partGroup{part: nextFree()}
+		for _, path := range sorted[start:end] {
+			group.photoIDs = append(group.photoIDs, dbFiles[path])
+		}
+		groups = append(groups, group)
+	}
+	return groups, keep, nil
+}
+
+// splitSet переносит группы фото фотосета set в части альбома и делает сам фотосет частью keep (пустая строка -
+// оставить как есть). Фото записываются в базу в новой части как ожидающие удаления из set, затем удаляются из set
+// на Flickr, см. flushRemovals. Ошибки API записываются в отчёт. Если какие-то фото не удалось перенести, фотосет
+// не переименовывается: деление продолжится при следующем запуске
+func (s *Service) splitSet(set flickruploader.Photoset, groups []partGroup, keep string,
+	existing map[string]flickruploader.Photoset) error {
+	moved := true
+	for _, group := range groups {
+		part, ok := existing[group.part]
+		newSet := !ok
+		primaryID := ""
+		if newSet {
+			part = flickruploader.Photoset{Name: partTitle(set.Album, group.part), Album: set.Album, Part: group.part}
+			primaryID = group.photoIDs[0]
+			log.Printf("Photoset '%s' doesn't exists. Create it. Main photo=%s", part.Name, primaryID)
+			var err error
+			part.ID, err = s.remoteStorage.CreatePhotoset(part.Name, primaryID)
+			if err != nil {
+				s.reportError(errors.Wrapf(err, "Can't create photoset %s %s", part.Name, primaryID))
+				moved = false
+				continue
+			}
+		}
+		if err := s.dbStorage.SetsSplit(part, newSet, primaryID, set.ID, group.photoIDs); err != nil {
+			return errors.Wrapf(err, "Can't move photos to photoset %s in db storage", part.ID)
+		}
+		s.touchSet(set.ID)
+		s.touchSet(part.ID)
+
+		if err := s.remoteStorage.RemovePhotosFromPhotoset(set.ID, group.photoIDs); err != nil {
+			s.reportError(errors.Wrapf(err, "Can't move photos from photoset '%s' to '%s'", set.Name, part.Name))
+			moved = false
+			continue
+		}
+		if err := s.dbStorage.PhotosClearRemoval(set.ID, group.photoIDs); err != nil {
+			return errors.Wrapf(err, "Can't record removal of photos from photoset %s", set.ID)
+		}
+	}
+
+	if !moved || keep == "" || keep == set.Part {
+		return nil
+	}
+	if err := s.renamePart(set, keep); err != nil {
+		s.reportError(err)
+	}
+	return nil
+}
+
+// flushRemovals удаляет на Flickr фото из фотосетов, из которых они перенесены в другие части альбома,
+// но не были удалены из-за ошибки или прерванного запуска. Возвращает фотосеты, из которых удалить фото не удалось
+func (s *Service) flushRemovals() (map[string]bool, error) {
+	pending, err := s.dbStorage.PhotosGetPendingRemovals()
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't get pending removals from photosets from db storage")
+	}
+	setIDs := make([]string, 0, len(pending))
+	for setID := range pending {
+		setIDs = append(setIDs, setID)
+	}
+	sort.Strings(setIDs)
+
+	failed := map[string]bool{}
+	for _, setID := range setIDs {
+		photoIDs := pending[setID]
+		log.Printf("Remove %d photos moved to other photosets from photoset %s", len(photoIDs), setID)
+		if err := s.remoteStorage.RemovePhotosFromPhotoset(setID, photoIDs); err != nil {
+			s.reportError(errors.Wrapf(err, "Can't remove moved photos from photoset %s", setID))
+			failed[setID] = true
+			continue
+		}
+		if err := s.dbStorage.PhotosClearRemoval(setID, photoIDs); err != nil {
+			return nil, errors.Wrapf(err, "Can't record removal of photos from photoset %s", setID)
+		}
+	}
+	return failed, nil
+}
+
+// deleteEmptySets удаляет из базы фотосеты, которые остались без фото в этом запуске: Flickr удаляет фотосет вместе
+// с последним фото. Умный альбом без фото получит новый фотосет, когда в него попадёт фото
+func (s *Service) deleteEmptySets() error {
+	touched := make([]string, 0, len(s.touchedSets))
+	for setID := range s.touchedSets {
+		touched = append(touched, setID)
+	}
+	sort.Strings(touched)
+	setIDs, err := s.dbStorage.SetsDeleteEmpty(touched)
+	if err != nil {
+		return errors.Wrap(err, "Can't delete empty photosets from db storage")
+	}
+	for _, setID := range setIDs {
+		log.Printf("Photoset %s has no photos left, it is deleted by Flickr", setID)
+	}
+
+	names, err := s.dbStorage.SmartAlbumsResetEmpty()
+	if err != nil {
+		return errors.Wrap(err, "Can't reset empty smart albums in db storage")
+	}
+	for _, name := range names {
+		log.Printf("Smart album '%s' has no photos left, it is deleted by Flickr", name)
+	}
+	return nil
+}